@@ -0,0 +1,150 @@
+package api
+
+import (
+	"backend/model"
+	"backend/types"
+	"backend/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type RebuildReqBody struct {
+	// rebuilds every user when empty
+	Username string `json:"username"`
+}
+
+// RebuildUser regenerates the stored games of a user from the archive cache. Stats requests for the
+// user wait on the db lock until it is done. A failed rebuild leaves the stored games untouched, so the
+// user's setup status goes back to what it was either way.
+func RebuildUser(userId string, state *types.ServerState) (stats model.InsertStatistics, err error) {
+	cache := state.ChessCom.Cache()
+	if cache == nil {
+		return stats, errors.New("archive cache disabled")
+	}
+
+	db := state.DBMap.Get(userId)
+	if db == nil {
+		return stats, fmt.Errorf("db for user %s doesn't exist", userId)
+	}
+
+	state.SetupStatuses.Mu.Lock()
+	previousStatus := (*state.SetupStatuses.Resource)[userId]
+	if previousStatus == types.SetupStatusStarted || previousStatus == types.SetupStatusUpdating {
+		state.SetupStatuses.Mu.Unlock()
+		return stats, errors.New("user data setup in progress")
+	}
+	(*state.SetupStatuses.Resource)[userId] = types.SetupStatusUpdating
+	state.SetupStatuses.Mu.Unlock()
+
+	db.Mu.Lock()
+	stats, err = model.RebuildUserData(db.Resource, userId, cache)
+	db.Mu.Unlock()
+
+	state.SetupStatuses.Mu.Lock()
+	defer state.SetupStatuses.Mu.Unlock()
+	(*state.SetupStatuses.Resource)[userId] = previousStatus
+
+	return
+}
+
+// writeRebuildJob encodes the latest rebuild job, or 404s when no rebuild has been started
+func writeRebuildJob(w http.ResponseWriter, status int, state *types.ServerState) {
+	state.LatestRebuild.Mu.Lock()
+	if state.LatestRebuild.Resource == nil {
+		state.LatestRebuild.Mu.Unlock()
+		http.Error(w, "No rebuild started", http.StatusNotFound)
+		return
+	}
+	response, err := json.Marshal(state.LatestRebuild.Resource)
+	state.LatestRebuild.Mu.Unlock()
+
+	if err != nil {
+		fmt.Printf("Error encoding rebuild job: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response)
+}
+
+// runRebuild rebuilds userIds one after the other, recording each result in job as it finishes
+func runRebuild(job *types.RebuildJob, userIds []string, state *types.ServerState) {
+	for _, userId := range userIds {
+		stats, err := RebuildUser(userId, state)
+
+		result := types.RebuildResult{Stats: &stats}
+		if err != nil {
+			fmt.Printf("Error rebuilding user %s: %s\n", userId, err)
+			result = types.RebuildResult{Error: err.Error()}
+		} else {
+			fmt.Printf("Rebuilt user %s:\n", userId)
+			PrintInsertStats(stats)
+		}
+
+		state.LatestRebuild.Mu.Lock()
+		job.Results[userId] = result
+		state.LatestRebuild.Mu.Unlock()
+	}
+
+	state.LatestRebuild.Mu.Lock()
+	defer state.LatestRebuild.Mu.Unlock()
+	finishedAt := time.Now().UTC()
+	job.Status = types.RebuildStatusComplete
+	job.FinishedAt = &finishedAt
+}
+
+// Rebuild starts rebuilding one user, or every user when no username is given, in the background on POST
+// and answers with the job, which GET reports on until the next rebuild is started. Only one rebuild runs
+// at a time.
+func Rebuild(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	if req.Method == http.MethodGet {
+		writeRebuildJob(w, http.StatusOK, state)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body RebuildReqBody
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var userIds []string
+	if body.Username != "" {
+		userId := utils.Hash(body.Username)
+		if state.DBMap.Get(userId) == nil {
+			http.Error(w, "User not setup", http.StatusBadRequest)
+			return
+		}
+		userIds = append(userIds, userId)
+	} else {
+		userIds = state.DBMap.UserIds()
+	}
+
+	state.LatestRebuild.Mu.Lock()
+	if job := state.LatestRebuild.Resource; job != nil && job.Status == types.RebuildStatusRunning {
+		state.LatestRebuild.Mu.Unlock()
+		http.Error(w, "Rebuild already running", http.StatusConflict)
+		return
+	}
+	job := &types.RebuildJob{
+		Status:    types.RebuildStatusRunning,
+		StartedAt: time.Now().UTC(),
+		NumUsers:  len(userIds),
+		Results:   make(map[string]types.RebuildResult),
+	}
+	state.LatestRebuild.Resource = job
+	state.LatestRebuild.Mu.Unlock()
+
+	go runRebuild(job, userIds, state)
+
+	writeRebuildJob(w, http.StatusAccepted, state)
+}
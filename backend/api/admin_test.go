@@ -0,0 +1,110 @@
+package api
+
+import (
+	"backend/model"
+	"backend/types"
+	"backend/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// numGames counts the games stored for bob
+func numGames(t *testing.T, state *types.ServerState) (count int) {
+	t.Helper()
+	db := state.DBMap.Get(utils.Hash("bob"))
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+	if err := db.Resource.QueryRow("SELECT COUNT(*) FROM games").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func setupStatus(state *types.ServerState, userId string) types.SetupStatus {
+	state.SetupStatuses.Mu.Lock()
+	defer state.SetupStatuses.Mu.Unlock()
+	return (*state.SetupStatuses.Resource)[userId]
+}
+
+func TestRebuildUser(t *testing.T) {
+	state := newTestState(t)
+	userId := utils.Hash("bob")
+	(*state.SetupStatuses.Resource)[userId] = types.SetupStatusComplete
+
+	stats, err := RebuildUser(userId, state)
+	if err != nil || stats.NumGamesInserted != 4 {
+		t.Fatalf("RebuildUser returned %+v, %v, expected the 4 games rebuilt", stats, err)
+	}
+	if status := setupStatus(state, userId); status != types.SetupStatusComplete {
+		t.Errorf("status %s after the rebuild, expected %s", status, types.SetupStatusComplete)
+	}
+
+	// without the cache the rebuild would drop every game, so it is refused and nothing changes
+	if err := os.RemoveAll(model.DefaultArchiveCacheDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RebuildUser(userId, state); err == nil {
+		t.Fatal("RebuildUser succeeded without the archive cache")
+	}
+	if status := setupStatus(state, userId); status != types.SetupStatusComplete {
+		t.Errorf("status %s after the refused rebuild, expected %s", status, types.SetupStatusComplete)
+	}
+	if count := numGames(t, state); count != 4 {
+		t.Errorf("%d games after the refused rebuild, expected 4", count)
+	}
+
+	recorder := httptest.NewRecorder()
+	Setup(recorder, httptest.NewRequest(http.MethodPost, "/setup", strings.NewReader(`{"username": "bob"}`)), state)
+	var resp SetupResp
+	if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil || resp.Status != types.SetupStatusComplete {
+		t.Errorf("setup after the refused rebuild answered %+v, %v, expected %s", resp, err, types.SetupStatusComplete)
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	state := newTestState(t)
+	userId := utils.Hash("bob")
+	if err := os.RemoveAll(model.DefaultArchiveCacheDir); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := httptest.NewRecorder()
+	Rebuild(recorder, httptest.NewRequest(http.MethodPost, "/admin/rebuild", strings.NewReader(`{}`)), state)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("POST returned %d: %s", recorder.Code, recorder.Body)
+	}
+
+	// setups add users while the rebuild runs
+	for i := 0; i < 10; i++ {
+		state.DBMap.Set(utils.Hash(strings.Repeat("x", i+1)), nil)
+	}
+
+	var job types.RebuildJob
+	for deadline := time.Now().Add(5 * time.Second); job.Status != types.RebuildStatusComplete; {
+		if time.Now().After(deadline) {
+			t.Fatalf("rebuild still %s", job.Status)
+		}
+		time.Sleep(time.Millisecond)
+
+		recorder := httptest.NewRecorder()
+		Rebuild(recorder, httptest.NewRequest(http.MethodGet, "/admin/rebuild", nil), state)
+		if err := json.NewDecoder(recorder.Body).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if job.NumUsers != 1 || job.Results[userId].Error == "" {
+		t.Errorf("rebuild job %+v, expected bob's rebuild to be refused", job)
+	}
+	if status := setupStatus(state, userId); status != "" {
+		t.Errorf("status %s after the refused rebuild, expected none", status)
+	}
+	if count := numGames(t, state); count != 4 {
+		t.Errorf("%d games after the refused rebuild, expected 4", count)
+	}
+}
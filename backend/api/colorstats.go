@@ -0,0 +1,149 @@
+package api
+
+import (
+	"backend/model"
+	"backend/types"
+	"backend/utils"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	winLossResults = []string{"resigned", "checkmated", "abandoned", "timeout"}
+	drawResults    = []string{"repetition", "insufficient", "timevsinsufficient", "stalemate", "agreed", "50move"}
+)
+
+type ColorStats struct {
+	Results GameStats    `json:"results"`
+	Wins    WinLossStats `json:"wins"`
+	Losses  WinLossStats `json:"losses"`
+	Draws   DrawStats    `json:"draws"`
+}
+
+// resultColumns counts the games matching condition for each of results, followed by the total
+func resultColumns(condition string, results []string) string {
+	var columns []string
+	for _, result := range results {
+		columns = append(columns, fmt.Sprintf(
+			"COALESCE(SUM(CASE WHEN %s AND g.result = '%s' THEN 1 ELSE NULL END), 0)", condition, result,
+		))
+	}
+	columns = append(columns, fmt.Sprintf("COALESCE(SUM(CASE WHEN %s THEN 1 ELSE NULL END), 0)", condition))
+	return strings.Join(columns, ",\n\t\t")
+}
+
+// GetColorStats gives the results and the ways games were won, lost and drawn when the user played white
+// and when they played black, keyed by time class then color. The stats filters apply.
+func GetColorStats(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	if !req.URL.Query().Has("username") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	username := req.URL.Query().Get("username")
+
+	if err := performSetupCheck(w, &state.SetupStatuses, username); err != nil {
+		fmt.Printf("Error getting color stats for user \"%s\": %s\n", username, err)
+		return
+	}
+
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queryStr := fmt.Sprintf(`
+	SELECT
+		tc.time_class,
+		c.color,%s,
+		%s,
+		%s,
+		%s
+	FROM (
+		SELECT DISTINCT time_class
+		FROM games
+	) tc
+	CROSS JOIN (
+		SELECT '%s' as color
+		UNION ALL
+		SELECT '%s'
+	) c
+	LEFT JOIN games g ON tc.time_class = g.time_class
+		AND CASE WHEN c.color = '%s' THEN g.white_player ELSE g.black_player END = ?1
+		AND %s
+	GROUP BY tc.time_class, c.color
+	`,
+		gameStatsColumns,
+		resultColumns("g.winner = ?1", winLossResults),
+		resultColumns("g.winner != ?1", winLossResults),
+		resultColumns("g.id IS NOT NULL AND g.winner IS NULL", drawResults),
+		model.White, model.Black, model.White,
+		filter.Clause(2),
+	)
+
+	db := state.DBMap.Get(utils.Hash(username))
+	if db == nil {
+		fmt.Println("Error making color stats query: db not found")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	rows, err := db.Resource.Query(queryStr, filter.Args(username)...)
+	if err != nil {
+		fmt.Printf("Error making color stats query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	response := make(map[string]map[string]ColorStats)
+	for rows.Next() {
+		var timeClass string
+		var color string
+		var stats ColorStats
+
+		if err := rows.Scan(
+			&timeClass,
+			&color,
+			&stats.Results.NumWins,
+			&stats.Results.NumLosses,
+			&stats.Results.NumDraws,
+			&stats.Results.Total,
+			&stats.Wins.NumResigns,
+			&stats.Wins.NumCheckmates,
+			&stats.Wins.NumAbandons,
+			&stats.Wins.NumTimeouts,
+			&stats.Wins.Total,
+			&stats.Losses.NumResigns,
+			&stats.Losses.NumCheckmates,
+			&stats.Losses.NumAbandons,
+			&stats.Losses.NumTimeouts,
+			&stats.Losses.Total,
+			&stats.Draws.NumRepetitions,
+			&stats.Draws.NumInsufficients,
+			&stats.Draws.NumTimeoutVsInsufficients,
+			&stats.Draws.NumStalemates,
+			&stats.Draws.NumAgrees,
+			&stats.Draws.Num50Rules,
+			&stats.Draws.Total,
+		); err != nil {
+			fmt.Printf("Error parsing color stats query result: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if response[timeClass] == nil {
+			response[timeClass] = make(map[string]ColorStats)
+		}
+		response[timeClass][color] = stats
+	}
+
+	if err := writeStats(w, req, response, "timeClass", "color"); err != nil {
+		fmt.Printf("Error encoding color stats query result: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
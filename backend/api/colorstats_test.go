@@ -0,0 +1,39 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestGetColorStats(t *testing.T) {
+	state := newTestState(t)
+
+	var stats map[string]map[string]ColorStats
+	get(t, state, GetColorStats, "/colorstats", url.Values{"username": {"bob"}, "variants": {"include"}}, &stats)
+
+	// as white bob mated alice and resigned the chess960 game, as black he lost on time and agreed a draw
+	expected := map[string]map[string]ColorStats{
+		"blitz": {
+			"white": {
+				Results: GameStats{NumWins: 1, NumLosses: 1, Total: 2},
+				Wins:    WinLossStats{NumCheckmates: 1, Total: 1},
+				Losses:  WinLossStats{NumResigns: 1, Total: 1},
+			},
+			"black": {
+				Results: GameStats{NumLosses: 1, Total: 1},
+				Losses:  WinLossStats{NumTimeouts: 1, Total: 1},
+			},
+		},
+		"rapid": {
+			"white": {},
+			"black": {
+				Results: GameStats{NumDraws: 1, Total: 1},
+				Draws:   DrawStats{NumAgrees: 1, Total: 1},
+			},
+		},
+	}
+	if fmt.Sprint(stats) != fmt.Sprint(expected) {
+		t.Errorf("color stats %+v, expected %+v", stats, expected)
+	}
+}
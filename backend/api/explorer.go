@@ -0,0 +1,127 @@
+package api
+
+import (
+	"backend/model"
+	"backend/types"
+	"backend/utils"
+	"fmt"
+	"net/http"
+)
+
+type ExplorerMove struct {
+	San       string  `json:"san"`
+	Uci       string  `json:"uci"`
+	NumWins   int     `json:"wins"`
+	NumLosses int     `json:"losses"`
+	NumDraws  int     `json:"draws"`
+	Total     int     `json:"total"`
+	WinPct    float64 `json:"winPct"`
+	LossPct   float64 `json:"lossPct"`
+	DrawPct   float64 `json:"drawPct"`
+}
+
+type Explorer struct {
+	Fen   string         `json:"fen"`
+	Color string         `json:"color"`
+	Moves []ExplorerMove `json:"moves"`
+}
+
+// GetExplorer lists the moves played from fen (the starting position by default) in the games the user played
+// as color, with results from the user's perspective, most played first. Games can be narrowed down with the
+// stats filters. A game that reaches the position more than once counts once per distinct move played from it.
+func GetExplorer(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	if !req.URL.Query().Has("username") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	username := req.URL.Query().Get("username")
+
+	color := req.URL.Query().Get("color")
+	if color != model.White.String() && color != model.Black.String() {
+		http.Error(w, "Color must be white or black", http.StatusBadRequest)
+		return
+	}
+
+	fen := model.StartingFen
+	if req.URL.Query().Has("fen") {
+		fen = req.URL.Query().Get("fen")
+	}
+	board, err := model.ParseFen(fen)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := performSetupCheck(w, &state.SetupStatuses, username); err != nil {
+		fmt.Printf("Error getting explorer for user \"%s\": %s\n", username, err)
+		return
+	}
+
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	playerColumn := "g.white_player"
+	if color == model.Black.String() {
+		playerColumn = "g.black_player"
+	}
+
+	queryStr := fmt.Sprintf(`
+	SELECT
+		m.san,
+		m.uci,%s
+	FROM (
+		SELECT DISTINCT game_id, san, uci
+		FROM game_positions
+		WHERE fen_id = ?2 AND san IS NOT NULL
+	) m
+	JOIN games g ON g.id = m.game_id
+	WHERE %s = ?1 AND %s
+	GROUP BY m.uci, m.san
+	ORDER BY total DESC, m.san
+	`, gameStatsColumns, playerColumn, filter.Clause(3))
+
+	db := state.DBMap.Get(utils.Hash(username))
+	if db == nil {
+		fmt.Println("Error making explorer query: db not found")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	rows, err := db.Resource.Query(queryStr, filter.Args(username, board.Hash())...)
+	if err != nil {
+		fmt.Printf("Error making explorer query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	response := Explorer{
+		Fen:   board.PositionFen(),
+		Color: color,
+		Moves: []ExplorerMove{},
+	}
+	for rows.Next() {
+		var move ExplorerMove
+		if err := rows.Scan(&move.San, &move.Uci, &move.NumWins, &move.NumLosses, &move.NumDraws, &move.Total); err != nil {
+			fmt.Printf("Error parsing explorer query result: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		move.WinPct = percentage(move.NumWins, move.Total)
+		move.LossPct = percentage(move.NumLosses, move.Total)
+		move.DrawPct = percentage(move.NumDraws, move.Total)
+		response.Moves = append(response.Moves, move)
+	}
+
+	if err := writeStats(w, req, response); err != nil {
+		fmt.Printf("Error encoding explorer query result: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
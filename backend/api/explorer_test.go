@@ -0,0 +1,29 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestGetExplorer(t *testing.T) {
+	state := newTestState(t)
+
+	var explorer Explorer
+	get(t, state, GetExplorer, "/explorer", url.Values{"username": {"bob"}, "color": {"black"}, "fen": {afterE4Fen}}, &explorer)
+
+	// bob drew the sicilian and lost the italian on time
+	expected := []ExplorerMove{
+		{San: "c5", Uci: "c7c5", NumDraws: 1, Total: 1, DrawPct: 100},
+		{San: "e5", Uci: "e7e5", NumLosses: 1, Total: 1, LossPct: 100},
+	}
+	if fen := "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq -"; explorer.Fen != fen || fmt.Sprint(explorer.Moves) != fmt.Sprint(expected) {
+		t.Errorf("explorer after 1. e4 listed %+v from %s, expected %+v", explorer.Moves, explorer.Fen, expected)
+	}
+
+	// the starting position is the default, where bob only played the scholar's mate as white once variants are excluded
+	get(t, state, GetExplorer, "/explorer", url.Values{"username": {"bob"}, "color": {"white"}}, &explorer)
+	if len(explorer.Moves) != 1 || explorer.Moves[0].San != "e4" || explorer.Moves[0].NumWins != 1 || explorer.Moves[0].Total != 1 {
+		t.Errorf("explorer from the starting position listed %+v, expected the winning e4", explorer.Moves)
+	}
+}
@@ -0,0 +1,305 @@
+package api
+
+import (
+	"backend/model"
+	"backend/types"
+	"backend/utils"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// number of games the exports read from the db at a time
+const exportBatchSize = 500
+
+// exportOrder orders the games aliased as g oldest first for the exports, which read them in batches
+// starting after the end time and id in ?1 and ?2. Games without an end time come first.
+const exportOrder = "(COALESCE(g.end_time, -1), g.id) > (?1, ?2) ORDER BY COALESCE(g.end_time, -1), g.id LIMIT ?3"
+
+// queryLocked runs queryStr and passes its rows to read while holding the lock on db. The exports read a
+// batch of games this way and write it after releasing the lock, so a slow download doesn't block the
+// user's other requests.
+func queryLocked(db *types.LockedDB, queryStr string, args []interface{}, read func(rows *sql.Rows) error) error {
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	rows, err := db.Resource.Query(queryStr, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if err := read(rows); err != nil {
+		return err
+	}
+	return rows.Err()
+}
+
+// terminations name the reason for each result in the Termination tag of exported games that don't have one
+var terminations = map[string]string{
+	"resigned":           "resignation",
+	"checkmated":         "checkmate",
+	"abandoned":          "game abandoned",
+	"timeout":            "time",
+	"repetition":         "repetition",
+	"insufficient":       "insufficient material",
+	"timevsinsufficient": "timeout vs insufficient material",
+	"stalemate":          "stalemate",
+	"agreed":             "agreement",
+	"50move":             "50-move rule",
+}
+
+// termination describes how a game ended for its Termination tag. winner is stored lowercase, so the name
+// is taken from the White or Black tag when it matches one of them.
+func termination(tags map[string]string, winner sql.NullString, result string) string {
+	reason, ok := terminations[result]
+	if !ok {
+		reason = result
+	}
+	if !winner.Valid {
+		return fmt.Sprintf("Game drawn by %s", reason)
+	}
+
+	name := winner.String
+	for _, player := range []string{tags["White"], tags["Black"]} {
+		if strings.EqualFold(player, winner.String) {
+			name = player
+		}
+	}
+	return fmt.Sprintf("%s won by %s", name, reason)
+}
+
+type pgnExportGame struct {
+	id          string
+	endTime     int64
+	pgn         string
+	whiteRating int
+	blackRating int
+	winner      sql.NullString
+	result      string
+	eco         sql.NullString
+	openingName sql.NullString
+}
+
+// exportPgn fills in the tags GetPgnExport adds to the stored pgn of game
+func exportPgn(game pgnExportGame) (string, error) {
+	parsed, err := model.ParsePgn(game.pgn)
+	if err != nil {
+		return "", err
+	}
+
+	tags := []model.PgnTag{
+		{Key: "WhiteElo", Value: strconv.Itoa(game.whiteRating)},
+		{Key: "BlackElo", Value: strconv.Itoa(game.blackRating)},
+	}
+	if _, ok := parsed.Tags["Termination"]; !ok {
+		tags = append(tags, model.PgnTag{Key: "Termination", Value: termination(parsed.Tags, game.winner, game.result)})
+	}
+	if game.eco.Valid {
+		tags = append(tags, model.PgnTag{Key: "ECO", Value: game.eco.String}, model.PgnTag{Key: "Opening", Value: game.openingName.String})
+	}
+	return model.SetPgnTags(game.pgn, tags)
+}
+
+// GetPgnExport streams every game matching the stats filters as one pgn file, oldest first. The ECO,
+// Opening, WhiteElo and BlackElo tags are filled in from what is stored for the game, chess.com's own ECO is
+// kept when the opening wasn't recognised. A Termination tag is added to games without one. Games stored
+// before their pgn was kept are left out.
+func GetPgnExport(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	exportPgnBatches(w, req, state, exportBatchSize)
+}
+
+// exportPgnBatches serves GetPgnExport reading batchSize games from the db at a time
+func exportPgnBatches(w http.ResponseWriter, req *http.Request, state *types.ServerState, batchSize int) {
+	if !req.URL.Query().Has("username") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	username := req.URL.Query().Get("username")
+
+	if err := performSetupCheck(w, &state.SetupStatuses, username); err != nil {
+		fmt.Printf("Error exporting pgn for user \"%s\": %s\n", username, err)
+		return
+	}
+
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queryStr := fmt.Sprintf(`
+	SELECT
+		g.id,
+		COALESCE(g.end_time, -1),
+		g.pgn,
+		g.white_rating,
+		g.black_rating,
+		g.winner,
+		g.result,
+		g.eco,
+		g.opening_name
+	FROM games g
+	WHERE g.pgn IS NOT NULL AND %s AND %s
+	`, filter.Clause(4), exportOrder)
+
+	db := state.DBMap.Get(utils.Hash(username))
+	if db == nil {
+		fmt.Println("Error making pgn export query: db not found")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	after := pgnExportGame{endTime: -1}
+	for batch := 0; ; batch++ {
+		var games []pgnExportGame
+		err := queryLocked(db, queryStr, filter.Args(after.endTime, after.id, batchSize), func(rows *sql.Rows) error {
+			for rows.Next() {
+				var game pgnExportGame
+				err := rows.Scan(&game.id, &game.endTime, &game.pgn, &game.whiteRating, &game.blackRating,
+					&game.winner, &game.result, &game.eco, &game.openingName)
+				if err != nil {
+					return err
+				}
+				games = append(games, game)
+			}
+			return nil
+		})
+		// the status is sent with the first batch, so errors after that can only end the file early
+		if err != nil {
+			fmt.Printf("Error making pgn export query: %s\n", err)
+			if batch == 0 {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if batch == 0 {
+			w.Header().Set("Content-Type", "application/x-chess-pgn")
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": username + ".pgn"}))
+		}
+
+		for _, game := range games {
+			pgn, err := exportPgn(game)
+			if err != nil {
+				fmt.Printf("Error exporting pgn of game %s: %s\n", game.id, err)
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "%s\n", pgn); err != nil {
+				fmt.Printf("Error writing pgn export: %s\n", err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		if len(games) < batchSize {
+			return
+		}
+		after = games[len(games)-1]
+	}
+}
+
+// GetGamesCsvExport streams every column of every game the user has stored as csv, oldest first
+func GetGamesCsvExport(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	exportGamesCsvBatches(w, req, state, exportBatchSize)
+}
+
+// exportGamesCsvBatches serves GetGamesCsvExport reading batchSize games from the db at a time
+func exportGamesCsvBatches(w http.ResponseWriter, req *http.Request, state *types.ServerState, batchSize int) {
+	if !req.URL.Query().Has("username") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	username := req.URL.Query().Get("username")
+
+	if err := performSetupCheck(w, &state.SetupStatuses, username); err != nil {
+		fmt.Printf("Error exporting games for user \"%s\": %s\n", username, err)
+		return
+	}
+
+	// the batch's last end time and id are selected after every column of games for the next batch to start after
+	queryStr := fmt.Sprintf(`
+	SELECT g.*, COALESCE(g.end_time, -1), g.id
+	FROM games g
+	WHERE %s
+	`, exportOrder)
+
+	db := state.DBMap.Get(utils.Hash(username))
+	if db == nil {
+		fmt.Println("Error making games export query: db not found")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	writer := csv.NewWriter(w)
+	var afterEndTime int64 = -1
+	var afterId string
+	for batch := 0; ; batch++ {
+		var columns []string
+		var records [][]string
+		err := queryLocked(db, queryStr, []interface{}{afterEndTime, afterId, batchSize}, func(rows *sql.Rows) (err error) {
+			columns, err = rows.Columns()
+			if err != nil {
+				return err
+			}
+			columns = columns[:len(columns)-2]
+
+			values := make([]interface{}, len(columns))
+			pointers := make([]interface{}, len(columns), len(columns)+2)
+			for i := range values {
+				pointers[i] = &values[i]
+			}
+			pointers = append(pointers, &afterEndTime, &afterId)
+
+			for rows.Next() {
+				if err := rows.Scan(pointers...); err != nil {
+					return err
+				}
+				fields := make([]string, len(columns))
+				for i, value := range values {
+					fields[i] = csvField(value)
+				}
+				records = append(records, fields)
+			}
+			return nil
+		})
+		// the status is sent with the header row, so errors after that can only end the file early
+		if err != nil {
+			fmt.Printf("Error making games export query: %s\n", err)
+			if batch == 0 {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		if batch == 0 {
+			w.Header().Set("Content-Type", csvContentType)
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": username + "-games.csv"}))
+			if err := writer.Write(columns); err != nil {
+				fmt.Printf("Error writing games export: %s\n", err)
+				return
+			}
+		}
+
+		if err := writer.WriteAll(records); err != nil {
+			fmt.Printf("Error writing games export: %s\n", err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(records) < batchSize {
+			return
+		}
+	}
+}
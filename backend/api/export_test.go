@@ -0,0 +1,185 @@
+package api
+
+import (
+	"backend/model"
+	"backend/types"
+	"backend/utils"
+	"encoding/csv"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// export calls handler with the query and returns its response body
+func export(t *testing.T, state *types.ServerState, handler func(http.ResponseWriter, *http.Request, *types.ServerState), query url.Values) string {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/export?"+query.Encode(), nil), state)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("export?%s returned %d: %s", query.Encode(), recorder.Code, recorder.Body)
+	}
+	return recorder.Body.String()
+}
+
+// inBatches is the export handler reading batchSize games at a time
+func inBatches(handler func(http.ResponseWriter, *http.Request, *types.ServerState, int), batchSize int) func(http.ResponseWriter, *http.Request, *types.ServerState) {
+	return func(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+		handler(w, req, state, batchSize)
+	}
+}
+
+func TestGetPgnExport(t *testing.T) {
+	state := newTestState(t)
+
+	for _, batchSize := range []int{exportBatchSize, 2, 1} {
+		var games []model.PgnGame
+		body := export(t, state, inBatches(exportPgnBatches, batchSize), url.Values{"username": {"bob"}})
+		for _, pgn := range strings.Split(strings.TrimSpace(body), "\n\n[") {
+			if !strings.HasPrefix(pgn, "[") {
+				pgn = "[" + pgn
+			}
+			game, err := model.ParsePgn(pgn)
+			if err != nil {
+				t.Fatalf("batches of %d: invalid pgn %q: %s", batchSize, pgn, err)
+			}
+			games = append(games, game)
+		}
+
+		// g3's own Termination is kept, the others are described with the names in their White and Black tags
+		expected := []struct {
+			white       string
+			whiteElo    string
+			termination string
+		}{
+			{"Bob", "1500", "Bob won by checkmate"},
+			{"Alice", "1520", "Game drawn by agreement"},
+			{"Carol", "1600", "Carol won on time"},
+		}
+		if len(games) != len(expected) {
+			t.Fatalf("batches of %d: exported %d games, expected %d", batchSize, len(games), len(expected))
+		}
+		for i, tt := range expected {
+			tags := games[i].Tags
+			if tags["White"] != tt.white || tags["WhiteElo"] != tt.whiteElo || tags["Termination"] != tt.termination {
+				t.Errorf("batches of %d: game %d exported with tags %v, expected White %s, WhiteElo %s and Termination %q",
+					batchSize, i, tags, tt.white, tt.whiteElo, tt.termination)
+			}
+		}
+	}
+
+	body := export(t, state, GetPgnExport, url.Values{"username": {"bob"}, "time_class": {"rapid"}})
+	if game, err := model.ParsePgn(body); err != nil || game.Tags["White"] != "Alice" || strings.Count(body, "[Event ") != 1 {
+		t.Errorf("rapid export %q, expected only the sicilian", body)
+	}
+
+	// results are bob's, the chess960 loss is left out with the other variants
+	for result, termination := range map[string]string{
+		"win":  "Bob won by checkmate",
+		"loss": "Carol won on time",
+		"draw": "Game drawn by agreement",
+	} {
+		body := export(t, state, GetPgnExport, url.Values{"username": {"bob"}, "result": {result}})
+		if game, err := model.ParsePgn(body); err != nil || game.Tags["Termination"] != termination || strings.Count(body, "[Event ") != 1 {
+			t.Errorf("export of bob's %s games %q, expected only the game where %s", result, body, termination)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	GetPgnExport(recorder, httptest.NewRequest(http.MethodGet, "/export/pgn?username=bob&result=won", nil), state)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("invalid result returned %d, expected %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestExportContentDisposition(t *testing.T) {
+	state := newTestState(t)
+
+	tests := []struct {
+		handler  func(http.ResponseWriter, *http.Request, *types.ServerState)
+		filename string
+	}{
+		{GetPgnExport, "bob.pgn"},
+		{GetGamesCsvExport, "bob-games.csv"},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		tt.handler(recorder, httptest.NewRequest(http.MethodGet, "/export?username=bob", nil), state)
+		disposition, params, err := mime.ParseMediaType(recorder.Header().Get("Content-Disposition"))
+		if err != nil || disposition != "attachment" || params["filename"] != tt.filename {
+			t.Errorf("Content-Disposition %q, expected an attachment named %s", recorder.Header().Get("Content-Disposition"), tt.filename)
+		}
+	}
+}
+
+// blockedWriter is a client that stops reading after the first write until released
+type blockedWriter struct {
+	*httptest.ResponseRecorder
+	written chan struct{}
+	release chan struct{}
+}
+
+func (w *blockedWriter) Write(b []byte) (int, error) {
+	select {
+	case w.written <- struct{}{}:
+		<-w.release
+	default:
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+// exportUnlocked checks handler doesn't hold the db lock between batches while it waits on a slow client
+func exportUnlocked(t *testing.T, state *types.ServerState, handler func(http.ResponseWriter, *http.Request, *types.ServerState, int)) {
+	t.Helper()
+	w := &blockedWriter{httptest.NewRecorder(), make(chan struct{}), make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		handler(w, httptest.NewRequest(http.MethodGet, "/export?username=bob", nil), state, 1)
+		close(done)
+	}()
+
+	<-w.written
+	db := state.DBMap.Get(utils.Hash("bob"))
+	if db.Mu.TryLock() {
+		db.Mu.Unlock()
+	} else {
+		t.Error("db locked while the export waits on the client")
+	}
+	close(w.release)
+	<-done
+}
+
+func TestGetPgnExportUnlocked(t *testing.T) {
+	exportUnlocked(t, newTestState(t), exportPgnBatches)
+}
+
+func TestGetGamesCsvExport(t *testing.T) {
+	state := newTestState(t)
+
+	for _, batchSize := range []int{exportBatchSize, 3, 2, 1} {
+		body := export(t, state, inBatches(exportGamesCsvBatches, batchSize), url.Values{"username": {"bob"}})
+		records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+		if err != nil {
+			t.Fatalf("batches of %d: invalid csv: %s", batchSize, err)
+		}
+		if len(records) != 5 || records[0][0] != "id" || records[0][len(records[0])-1] == "id" {
+			t.Fatalf("batches of %d: exported %v, expected the games columns and 4 games", batchSize, records)
+		}
+		var ids []string
+		for _, record := range records[1:] {
+			if len(record) != len(records[0]) {
+				t.Errorf("batches of %d: record %v doesn't match the header %v", batchSize, record, records[0])
+			}
+			ids = append(ids, record[0])
+		}
+		if strings.Join(ids, " ") != "g1 g2 g3 g4" {
+			t.Errorf("batches of %d: exported games %v, expected g1 g2 g3 g4", batchSize, ids)
+		}
+	}
+}
+
+func TestGetGamesCsvExportUnlocked(t *testing.T) {
+	exportUnlocked(t, newTestState(t), exportGamesCsvBatches)
+}
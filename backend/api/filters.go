@@ -0,0 +1,223 @@
+package api
+
+import (
+	"backend/model"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+var timeClasses = map[string]bool{"bullet": true, "blitz": true, "rapid": true, "daily": true}
+
+var timeControlRegex = regexp.MustCompile(`^(\d+(\+\d+)?|1/\d+)$`)
+
+var ecoRegex = regexp.MustCompile(`^[A-E]\d{0,2}$`)
+
+// gameFilter is a condition on the games table aliased as g, built from the query parameters shared by
+// the stats endpoints. Queries number every placeholder, ?1 onwards for the handler's own arguments and
+// the filter's after them, so the clause can go anywhere in the query.
+type gameFilter struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (f *gameFilter) add(condition string, args ...interface{}) {
+	f.conditions = append(f.conditions, condition)
+	f.args = append(f.args, args...)
+}
+
+// Clause numbers the filter's placeholders from ?first, the one after the handler's own leading parameters
+func (f gameFilter) Clause(first int) string {
+	if len(f.conditions) == 0 {
+		return "1"
+	}
+
+	var sb strings.Builder
+	n := first
+	for _, c := range strings.Join(f.conditions, " AND ") {
+		if c == '?' {
+			fmt.Fprintf(&sb, "?%d", n)
+			n++
+		} else {
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+// Next is the number of the parameter after the filter's when they start at ?first, for the handler's
+// trailing arguments
+func (f gameFilter) Next(first int) int {
+	return first + len(f.args)
+}
+
+// Args appends the filter's arguments to the handler's own leading ones
+func (f gameFilter) Args(args ...interface{}) []interface{} {
+	return append(args, f.args...)
+}
+
+// parseGameFilter reads the filter query parameters:
+//
+//	variants             exclude (default), include or only
+//	from, to             inclusive YYYY-MM-DD dates the game ended between
+//	rated                true or false
+//	color                white or black, the color username played
+//	result               win, loss or draw, from username's side
+//	min_opponent_rating  inclusive bounds on the opponent's rating
+//	max_opponent_rating
+//	time_class           comma separated list of bullet, blitz, rapid and daily
+//	time_control         exact chess.com time control, such as 180+2 or 1/86400
+//	eco                  eco code or prefix of one, such as B or B2 or B22
+//	opening              prefix of the opening name, such as Sicilian Defense
+func parseGameFilter(req *http.Request, username string) (filter gameFilter, err error) {
+	query := req.URL.Query()
+
+	switch variants := query.Get("variants"); variants {
+	case "", "exclude":
+		filter.add("g.variant = ?", model.VariantStandard)
+	case "include":
+	case "only":
+		filter.add("g.variant != ?", model.VariantStandard)
+	default:
+		return filter, fmt.Errorf("invalid variants value, expected exclude, include or only: %s", variants)
+	}
+
+	from, to, err := dateRange(req)
+	if err != nil {
+		return
+	}
+	if from != 0 {
+		filter.add("g.end_time >= ?", from)
+	}
+	if to != math.MaxInt64 {
+		filter.add("g.end_time < ?", to)
+	}
+
+	if ratedStr := query.Get("rated"); ratedStr != "" {
+		rated, err := strconv.ParseBool(ratedStr)
+		if err != nil {
+			return filter, fmt.Errorf("invalid rated value, expected true or false: %s", ratedStr)
+		}
+		filter.add("g.rated = ?", rated)
+	}
+
+	switch color := query.Get("color"); color {
+	case "":
+	case model.White.String():
+		filter.add("g.white_player = ?", username)
+	case model.Black.String():
+		filter.add("g.black_player = ?", username)
+	default:
+		return filter, fmt.Errorf("invalid color, expected white or black: %s", color)
+	}
+
+	switch result := query.Get("result"); result {
+	case "":
+	case "win":
+		filter.add("g.winner = ?", username)
+	case "loss":
+		filter.add("g.winner != ?", username)
+	case "draw":
+		filter.add("g.winner IS NULL")
+	default:
+		return filter, fmt.Errorf("invalid result, expected win, loss or draw: %s", result)
+	}
+
+	opponentRating := "CASE WHEN g.white_player = ? THEN g.black_rating ELSE g.white_rating END"
+	minRating, hasMinRating, err := ratingParam(req, "min_opponent_rating")
+	if err != nil {
+		return
+	}
+	maxRating, hasMaxRating, err := ratingParam(req, "max_opponent_rating")
+	if err != nil {
+		return
+	}
+	if hasMinRating && hasMaxRating && minRating > maxRating {
+		return filter, fmt.Errorf("min_opponent_rating must not be greater than max_opponent_rating")
+	}
+	if hasMinRating {
+		filter.add(opponentRating+" >= ?", username, minRating)
+	}
+	if hasMaxRating {
+		filter.add(opponentRating+" <= ?", username, maxRating)
+	}
+
+	if timeClassStr := query.Get("time_class"); timeClassStr != "" {
+		var placeholders []string
+		var args []interface{}
+		for _, timeClass := range strings.Split(timeClassStr, ",") {
+			if !timeClasses[timeClass] {
+				return filter, fmt.Errorf("invalid time_class, expected bullet, blitz, rapid or daily: %s", timeClass)
+			}
+			placeholders = append(placeholders, "?")
+			args = append(args, timeClass)
+		}
+		filter.add(fmt.Sprintf("g.time_class IN (%s)", strings.Join(placeholders, ", ")), args...)
+	}
+
+	if timeControl := query.Get("time_control"); timeControl != "" {
+		if !timeControlRegex.MatchString(timeControl) {
+			return filter, fmt.Errorf("invalid time_control, expected a chess.com time control such as 180+2: %s", timeControl)
+		}
+		filter.add("g.time_control = ?", timeControl)
+	}
+
+	if eco := query.Get("eco"); eco != "" {
+		if !ecoRegex.MatchString(eco) {
+			return filter, fmt.Errorf("invalid eco, expected a code such as B22 or a prefix of one: %s", eco)
+		}
+		filter.add("g.eco LIKE ?", eco+"%")
+	}
+
+	if opening := query.Get("opening"); opening != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(opening)
+		filter.add(`g.opening_name LIKE ? ESCAPE '\'`, escaped+"%")
+	}
+
+	return
+}
+
+func ratingParam(req *http.Request, name string) (rating int, ok bool, err error) {
+	ratingStr := req.URL.Query().Get(name)
+	if ratingStr == "" {
+		return
+	}
+
+	rating, err = strconv.Atoi(ratingStr)
+	if err != nil || rating < 0 {
+		return 0, false, fmt.Errorf("invalid %s, expected a non-negative integer: %s", name, ratingStr)
+	}
+	return rating, true, nil
+}
+
+// dateRange reads the from and to query parameters, both YYYY-MM-DD and inclusive, as unix times to compare
+// end_time against. to is returned exclusive, as the start of the following day.
+func dateRange(req *http.Request) (from int64, to int64, err error) {
+	to = math.MaxInt64
+	if fromStr := req.URL.Query().Get("from"); fromStr != "" {
+		date, err := time.Parse(dateLayout, fromStr)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid from date, expected YYYY-MM-DD: %s", fromStr)
+		}
+		from = date.Unix()
+	}
+
+	if toStr := req.URL.Query().Get("to"); toStr != "" {
+		date, err := time.Parse(dateLayout, toStr)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid to date, expected YYYY-MM-DD: %s", toStr)
+		}
+		to = date.AddDate(0, 0, 1).Unix()
+	}
+
+	if from >= to {
+		return 0, 0, fmt.Errorf("from date must not be after to date")
+	}
+	return
+}
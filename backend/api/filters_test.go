@@ -0,0 +1,240 @@
+package api
+
+import (
+	"backend/chesscomtest"
+	"backend/model"
+	"backend/types"
+	"backend/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const afterE4Fen = "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1"
+
+// newTestState ingests chesscomtest.BobsArchives from a fake chess.com into a db in a temporary working
+// directory, where performSetupCheck looks for it, caching the archives in the default archive cache dir
+func newTestState(t *testing.T) *types.ServerState {
+	t.Helper()
+	server := chesscomtest.NewBobServer(t)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	userId := utils.Hash("bob")
+	db, err := model.OpenUserDb(userId)
+	if err != nil {
+		t.Fatalf("error opening db: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	config := model.DefaultChessComConfig()
+	config.BaseUrl = server.URL
+	config.MaxAttempts = 1
+	config.RequestsPerSecond = 0
+	config.Cache = model.NewArchiveCache(model.DefaultArchiveCacheDir)
+	client := model.NewChessComClient(config)
+
+	archives, err := client.ListArchives("bob")
+	if err != nil {
+		t.Fatalf("ListArchives: %s", err)
+	}
+	games := make(chan model.Game)
+	go client.GetAllGames(context.Background(), archives, games)
+	stats, err := model.InsertUserData(db, userId, "bob", games, archives)
+	if err != nil || stats.NumGamesInserted != 4 {
+		t.Fatalf("InsertUserData returned %+v, %v, expected 4 games inserted", stats, err)
+	}
+
+	state := types.NewServerState(client)
+	state.DBMap.Set(userId, types.NewLockedDB(db))
+	return state
+}
+
+// get calls handler with the query and decodes its json response into response
+func get(t *testing.T, state *types.ServerState, handler func(http.ResponseWriter, *http.Request, *types.ServerState), path string, query url.Values, response interface{}) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, path+"?"+query.Encode(), nil), state)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("%s?%s returned %d: %s", path, query.Encode(), recorder.Code, recorder.Body)
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(response); err != nil {
+		t.Fatalf("%s?%s returned invalid json: %s", path, query.Encode(), err)
+	}
+}
+
+// the filter's placeholders follow the handler's own, whichever side of the filter they are on in the query
+func TestGameFilterPlaceholders(t *testing.T) {
+	state := newTestState(t)
+
+	t.Run("games sorted by rating with a cursor", func(t *testing.T) {
+		query := url.Values{
+			"username":            {"bob"},
+			"sort":                {"rating"},
+			"limit":               {"1"},
+			"time_class":          {"blitz"},
+			"min_opponent_rating": {"1400"},
+		}
+		var ids []string
+		for page := 0; page < 3; page++ {
+			var games Games
+			get(t, state, GetGames, "/games", query, &games)
+			for _, game := range games.Games {
+				ids = append(ids, game.Id)
+			}
+			if games.NextCursor == "" {
+				break
+			}
+			query.Set("cursor", games.NextCursor)
+		}
+		if len(ids) != 2 || ids[0] != "g1" || ids[1] != "g3" {
+			t.Errorf("pages listed games %v, expected g1 then g3", ids)
+		}
+	})
+
+	t.Run("game stats", func(t *testing.T) {
+		var stats map[string]GameStats
+		get(t, state, GetGameStats, "/stats/games", url.Values{
+			"username":            {"bob"},
+			"color":               {"black"},
+			"max_opponent_rating": {"1550"},
+		}, &stats)
+		if stats["blitz"].Total != 0 || stats["rapid"] != (GameStats{NumDraws: 1, Total: 1}) {
+			t.Errorf("game stats %+v, expected only the rapid draw", stats)
+		}
+	})
+
+	t.Run("time stats", func(t *testing.T) {
+		var stats map[string]TimeStats
+		get(t, state, GetTimeStats, "/stats/time", url.Values{
+			"username":   {"bob"},
+			"color":      {"black"},
+			"time_class": {"blitz"},
+		}, &stats)
+		if blitz := stats["blitz"]; blitz.Total != 1 || blitz.NumTimeTrouble != 1 || blitz.AvgOpeningMoveSeconds != 85 {
+			t.Errorf("blitz time stats %+v, expected only the italian", blitz)
+		}
+	})
+
+	t.Run("opening stats", func(t *testing.T) {
+		var stats map[string]map[string][]OpeningStats
+		get(t, state, GetOpeningStats, "/stats/openings", url.Values{
+			"username": {"bob"},
+			"opening":  {"Sicilian"},
+		}, &stats)
+		if len(stats) != 1 || len(stats["rapid"]["black"]) != 1 || stats["rapid"]["black"][0].Eco != "B90" {
+			t.Errorf("opening stats %v, expected only the najdorf", stats)
+		}
+	})
+
+	t.Run("rating history", func(t *testing.T) {
+		var history map[string][]RatingBucket
+		get(t, state, GetRatingHistory, "/ratinghistory", url.Values{
+			"username":   {"bob"},
+			"bucket":     {"month"},
+			"time_class": {"blitz"},
+			"color":      {"white"},
+			"variants":   {"include"},
+		}, &history)
+		expected := []RatingBucket{{"2023-11", 1500, 1500, 1500, 1}, {"2023-12", 1480, 1480, 1480, 1}}
+		if fmt.Sprint(history["blitz"]) != fmt.Sprint(expected) {
+			t.Errorf("blitz rating history %v, expected %v", history["blitz"], expected)
+		}
+	})
+
+	t.Run("results over time", func(t *testing.T) {
+		var results map[string][]PeriodGameStats
+		get(t, state, GetResultsOverTime, "/resultsovertime", url.Values{
+			"username":            {"bob"},
+			"color":               {"black"},
+			"min_opponent_rating": {"1550"},
+			"variants":            {"include"},
+		}, &results)
+		expected := []PeriodGameStats{{"2023-12", GameStats{NumLosses: 1, Total: 1}}}
+		if len(results) != 1 || fmt.Sprint(results["blitz"]) != fmt.Sprint(expected) {
+			t.Errorf("results over time %v, expected only the italian's loss", results)
+		}
+	})
+
+	t.Run("color stats", func(t *testing.T) {
+		var stats map[string]map[string]ColorStats
+		get(t, state, GetColorStats, "/colorstats", url.Values{
+			"username":   {"bob"},
+			"time_class": {"blitz"},
+			"variants":   {"only"},
+		}, &stats)
+		if white := stats["blitz"]["white"]; white.Losses != (WinLossStats{NumResigns: 1, Total: 1}) || stats["blitz"]["black"].Results.Total != 0 {
+			t.Errorf("color stats %+v, expected only the chess960 resignation", stats)
+		}
+	})
+
+	t.Run("explorer", func(t *testing.T) {
+		var explorer Explorer
+		get(t, state, GetExplorer, "/explorer", url.Values{
+			"username":   {"bob"},
+			"color":      {"black"},
+			"fen":        {afterE4Fen},
+			"time_class": {"rapid"},
+		}, &explorer)
+		if len(explorer.Moves) != 1 || explorer.Moves[0].San != "c5" {
+			t.Errorf("explorer listed %+v, expected only the sicilian", explorer.Moves)
+		}
+	})
+
+	t.Run("positions with pagination", func(t *testing.T) {
+		var stats PositionStats
+		get(t, state, GetPositions, "/positions", url.Values{
+			"username":   {"bob"},
+			"fen":        {afterE4Fen},
+			"color":      {"black"},
+			"time_class": {"blitz,rapid"},
+			"limit":      {"1"},
+			"offset":     {"1"},
+		}, &stats)
+		if stats.Total != 2 || stats.NumDraws != 1 || stats.NumLosses != 1 || len(stats.Games) != 1 {
+			t.Errorf("position stats %+v, expected a draw and a loss with one game listed", stats)
+		}
+	})
+
+	t.Run("opponent with pagination", func(t *testing.T) {
+		var headToHead HeadToHead
+		get(t, state, GetOpponent, "/opponents/carol", url.Values{
+			"username": {"bob"},
+			"color":    {"white"},
+			"variants": {"include"},
+			"limit":    {"1"},
+			"offset":   {"0"},
+		}, &headToHead)
+		if headToHead.TimeClasses["blitz"].NumLosses != 1 || len(headToHead.Games) != 1 || headToHead.Games[0].Id != "g4" {
+			t.Errorf("head to head %+v, expected only the chess960 game", headToHead)
+		}
+	})
+
+	t.Run("opponents with pagination", func(t *testing.T) {
+		var opponents Opponents
+		get(t, state, GetOpponents, "/opponents", url.Values{
+			"username":   {"bob"},
+			"time_class": {"blitz"},
+			"rated":      {"true"},
+			"limit":      {"1"},
+			"offset":     {"1"},
+		}, &opponents)
+		if opponents.Total != 2 || len(opponents.Opponents) != 1 {
+			t.Errorf("opponents %+v, expected 2 in total and one listed", opponents)
+		}
+	})
+}
@@ -0,0 +1,291 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	jsonContentType   = "application/json"
+	csvContentType    = "text/csv"
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// responseFormat picks the media type the stats endpoints can produce that the Accept header gives the highest
+// q-value, the first listed on a tie. The most specific media range matching a type sets its q-value, so
+// wildcards stand for json before the others. ok is false when the header rules out all of them, a missing
+// header accepts json.
+func responseFormat(req *http.Request) (format string, ok bool) {
+	accept := req.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return jsonContentType, true
+	}
+
+	type quality struct {
+		q        float64
+		position int
+	}
+	ranges := make(map[string]quality)
+	for i, accepted := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qStr, found := params["q"]; found {
+			if q, err = strconv.ParseFloat(qStr, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		if _, found := ranges[mediaRange]; !found {
+			ranges[mediaRange] = quality{q, i}
+		}
+	}
+
+	var best quality
+	for _, mediaType := range []string{jsonContentType, csvContentType, ndjsonContentType} {
+		mainType, _, _ := strings.Cut(mediaType, "/")
+		for _, mediaRange := range []string{mediaType, mainType + "/*", "*/*"} {
+			quality, found := ranges[mediaRange]
+			if !found {
+				continue
+			}
+			if quality.q > best.q || quality.q == best.q && quality.position < best.position {
+				format, best = mediaType, quality
+			}
+			break
+		}
+	}
+	return format, best.q > 0
+}
+
+// writeStats encodes response as json, csv or ndjson depending on the Accept header. For csv and ndjson
+// response is flattened into records: every map key becomes a column named by the next of keys, every slice
+// element its own record, an empty slice or map a record with its columns empty, and nested struct fields are
+// named by their json path, e.g. wins.resigns. A response checkFlat rejects is only available as json. When
+// none of the formats it's available in is acceptable writeStats answers 406 Not Acceptable itself.
+func writeStats(w http.ResponseWriter, req *http.Request, response interface{}, keys ...string) error {
+	w.Header().Add("Vary", "Accept")
+	format, ok := responseFormat(req)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Not acceptable, expected %s, %s or %s", jsonContentType, csvContentType, ndjsonContentType), http.StatusNotAcceptable)
+		return nil
+	}
+
+	value := reflect.ValueOf(response)
+	if format != jsonContentType && checkFlat(value.Type()) != nil {
+		http.Error(w, fmt.Sprintf("Not acceptable, only available as %s", jsonContentType), http.StatusNotAcceptable)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", format)
+	if format == jsonContentType {
+		return json.NewEncoder(w).Encode(response)
+	}
+
+	columns := flatColumns(value.Type(), "", keys)
+	records := flatRecords(value)
+
+	if format == ndjsonContentType {
+		encoder := json.NewEncoder(w)
+		for _, record := range records {
+			object := make(map[string]interface{}, len(columns))
+			for i, column := range columns {
+				object[column] = record[i]
+			}
+			if err := encoder.Encode(object); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	for _, record := range records {
+		fields := make([]string, len(record))
+		for i, field := range record {
+			fields[i] = csvField(field)
+		}
+		if err := writer.Write(fields); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvField(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// jsonName is the name encoding/json gives field, empty if it isn't encoded
+func jsonName(field reflect.StructField) string {
+	if field.PkgPath != "" {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// repeats reports whether a value of type t can flatten into more than one record
+func repeats(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return repeats(t.Elem())
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return true
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if jsonName(t.Field(i)) != "" && repeats(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkFlat rejects the types flatRecords can't flatten: structs with more than one field that repeats,
+// whose records would be every combination of those fields' records
+func checkFlat(t reflect.Type) error {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Array:
+		return checkFlat(t.Elem())
+	case reflect.Struct:
+		var repeated []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if jsonName(field) == "" {
+				continue
+			}
+			if err := checkFlat(field.Type); err != nil {
+				return err
+			}
+			if repeats(field.Type) {
+				repeated = append(repeated, field.Name)
+			}
+		}
+		if len(repeated) > 1 {
+			return fmt.Errorf("can't flatten %s, more than one of its fields repeats: %s", t, strings.Join(repeated, ", "))
+		}
+	}
+	return nil
+}
+
+// flatColumns names the fields of the records flatRecords makes from a value of type t
+func flatColumns(t reflect.Type, prefix string, keys []string) []string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return flatColumns(t.Elem(), prefix, keys)
+	case reflect.Map:
+		key := "key"
+		if len(keys) > 0 {
+			key, keys = keys[0], keys[1:]
+		}
+		return append([]string{prefix + key}, flatColumns(t.Elem(), prefix, keys)...)
+	case reflect.Slice, reflect.Array:
+		return flatColumns(t.Elem(), prefix, keys)
+	case reflect.Struct:
+		var columns []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := jsonName(field)
+			if name == "" {
+				continue
+			}
+			if field.Anonymous {
+				columns = append(columns, flatColumns(field.Type, prefix, keys)...)
+			} else {
+				columns = append(columns, flatColumns(field.Type, prefix+name+".", keys)...)
+			}
+		}
+		return columns
+	default:
+		return []string{strings.TrimSuffix(prefix, ".")}
+	}
+}
+
+// flatRecords turns v, of a type checkFlat accepts, into records of the columns given by flatColumns. The
+// records of the fields of a struct are combined with each other, so a struct holding a slice gives a record
+// per element that repeats the struct's other fields, or a single record when the slice is empty. An empty map
+// is a single record too, with its key empty.
+func flatRecords(v reflect.Value) [][]interface{} {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return [][]interface{}{make([]interface{}, len(flatColumns(v.Type(), "", nil)))}
+		}
+		return flatRecords(v.Elem())
+	case reflect.Map:
+		if v.Len() == 0 {
+			return [][]interface{}{make([]interface{}, len(flatColumns(v.Type(), "", nil)))}
+		}
+
+		mapKeys := v.MapKeys()
+		sort.Slice(mapKeys, func(i, j int) bool {
+			return fmt.Sprint(mapKeys[i].Interface()) < fmt.Sprint(mapKeys[j].Interface())
+		})
+
+		var records [][]interface{}
+		for _, key := range mapKeys {
+			for _, record := range flatRecords(v.MapIndex(key)) {
+				records = append(records, append([]interface{}{key.Interface()}, record...))
+			}
+		}
+		return records
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			return [][]interface{}{make([]interface{}, len(flatColumns(v.Type().Elem(), "", nil)))}
+		}
+
+		var records [][]interface{}
+		for i := 0; i < v.Len(); i++ {
+			records = append(records, flatRecords(v.Index(i))...)
+		}
+		return records
+	case reflect.Struct:
+		records := [][]interface{}{{}}
+		for i := 0; i < v.NumField(); i++ {
+			if jsonName(v.Type().Field(i)) == "" {
+				continue
+			}
+
+			var combined [][]interface{}
+			for _, record := range records {
+				for _, fieldRecord := range flatRecords(v.Field(i)) {
+					combined = append(combined, append(append([]interface{}{}, record...), fieldRecord...))
+				}
+			}
+			records = combined
+		}
+		return records
+	default:
+		return [][]interface{}{{v.Interface()}}
+	}
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"backend/model"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeStatsAs(t *testing.T, accept string, response interface{}, keys ...string) (*httptest.ResponseRecorder, error) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req.Header.Set("Accept", accept)
+	recorder := httptest.NewRecorder()
+	err := writeStats(recorder, req, response, keys...)
+	return recorder, err
+}
+
+func TestWriteStats(t *testing.T) {
+	ply := int64(3)
+	tests := []struct {
+		name     string
+		response interface{}
+		keys     []string
+		csv      string
+	}{
+		{
+			"game stats",
+			map[string]GameStats{"rapid": {1, 0, 1, 2}, "blitz": {NumWins: 1, Total: 1}},
+			[]string{"timeClass"},
+			"timeClass,wins,losses,draws,total\nblitz,1,0,0,1\nrapid,1,0,1,2\n",
+		},
+		{
+			"draw stats",
+			map[string]DrawStats{"blitz": {NumStalemates: 1, Total: 1}},
+			[]string{"timeClass"},
+			"timeClass,repetitions,insufficients,timeoutVsInsufficients,stalemates,agrees,fiftyMoveRules,total\nblitz,0,0,0,1,0,0,1\n",
+		},
+		{
+			"color stats",
+			map[string]map[string]ColorStats{"blitz": {"white": {Results: GameStats{NumWins: 1, Total: 1}, Wins: WinLossStats{NumCheckmates: 1, Total: 1}}}},
+			[]string{"timeClass", "color"},
+			"timeClass,color,results.wins,results.losses,results.draws,results.total," +
+				"wins.resigns,wins.checkmates,wins.abandons,wins.timeouts,wins.total," +
+				"losses.resigns,losses.checkmates,losses.abandons,losses.timeouts,losses.total," +
+				"draws.repetitions,draws.insufficients,draws.timeoutVsInsufficients,draws.stalemates,draws.agrees,draws.fiftyMoveRules,draws.total\n" +
+				"blitz,white,1,0,0,1,0,1,0,0,1,0,0,0,0,0,0,0,0,0,0,0,0\n",
+		},
+		{
+			"opening stats",
+			map[string]map[string][]OpeningStats{"blitz": {
+				"black": {},
+				"white": {{"C20", "King's Pawn Opening", 1, 0, 0, 1}, {"C50", "Italian Game", 0, 1, 0, 1}},
+			}},
+			[]string{"timeClass", "color"},
+			"timeClass,color,eco,name,wins,losses,draws,total\nblitz,black,,,,,,\nblitz,white,C20,King's Pawn Opening,1,0,0,1\nblitz,white,C50,Italian Game,0,1,0,1\n",
+		},
+		{
+			"opening stats without games",
+			map[string]map[string][]OpeningStats{"blitz": {}},
+			[]string{"timeClass", "color"},
+			"timeClass,color,eco,name,wins,losses,draws,total\nblitz,,,,,,,\n",
+		},
+		{
+			"game stats without time classes",
+			map[string]GameStats{},
+			[]string{"timeClass"},
+			"timeClass,wins,losses,draws,total\n,,,,\n",
+		},
+		{
+			"rating history",
+			map[string][]RatingBucket{"blitz": {{"2023-11", 1500, 1480, 1510, 4}}, "rapid": nil},
+			[]string{"timeClass"},
+			"timeClass,bucket,last,min,max,games\nblitz,2023-11,1500,1480,1510,4\nrapid,,,,,\n",
+		},
+		{
+			"results over time",
+			map[string][]PeriodGameStats{"blitz": {{"2023-11", GameStats{1, 1, 0, 2}}, {"2023-12", GameStats{0, 1, 0, 1}}}},
+			[]string{"timeClass"},
+			"timeClass,bucket,wins,losses,draws,total\nblitz,2023-11,1,1,0,2\nblitz,2023-12,0,1,0,1\n",
+		},
+		{
+			"time stats",
+			map[string]TimeStats{"blitz": {AvgOpeningMoveSeconds: 1.5, NumTimeTrouble: 1, TimeTroublePct: 50, NumWithClocks: 2, Total: 3}},
+			[]string{"timeClass"},
+			"timeClass,avgOpeningMoveSeconds,avgMiddlegameMoveSeconds,avgEndgameMoveSeconds,lostOnTime,lostOnTimeWinning," +
+				"lostOnTimeWinningPct,timeTrouble,timeTroublePct,withClocks,total\nblitz,1.5,0,0,0,0,0,1,50,2,3\n",
+		},
+		{
+			"explorer",
+			Explorer{Fen: model.StartingFen, Color: "white", Moves: []ExplorerMove{{"e4", "e2e4", 1, 0, 1, 2, 0.5, 0, 0.5}}},
+			nil,
+			"fen,color,moves.san,moves.uci,moves.wins,moves.losses,moves.draws,moves.total,moves.winPct,moves.lossPct,moves.drawPct\n" +
+				model.StartingFen + ",white,e4,e2e4,1,0,1,2,0.5,0,0.5\n",
+		},
+		{
+			"explorer without moves",
+			Explorer{Fen: model.StartingFen, Color: "black", Moves: []ExplorerMove{}},
+			nil,
+			"fen,color,moves.san,moves.uci,moves.wins,moves.losses,moves.draws,moves.total,moves.winPct,moves.lossPct,moves.drawPct\n" +
+				model.StartingFen + ",black,,,,,,,,,\n",
+		},
+		{
+			"opponents",
+			Opponents{Total: 2, Opponents: []OpponentStats{{"alice", GameStats{1, 0, 1, 2}, 20, "2023-11-20T00:00:00Z"}}},
+			nil,
+			"total,opponents.name,opponents.wins,opponents.losses,opponents.draws,opponents.total,opponents.ratingDiff,opponents.lastPlayed\n" +
+				"2,alice,1,0,1,2,20,2023-11-20T00:00:00Z\n",
+		},
+		{
+			"positions",
+			PositionStats{Fen: model.StartingFen, NumWins: 1, Total: 1, Games: []PositionGame{
+				{"https://www.chess.com/game/live/g1", "white", "alice", 1500, 1450, "win", &ply},
+				{"https://www.chess.com/game/live/g2", "black", "alice", 1510, 1520, "draw", nil},
+			}},
+			nil,
+			"fen,wins,losses,draws,total,games.url,games.color,games.opponent,games.rating,games.opponentRating,games.result,games.ply\n" +
+				model.StartingFen + ",1,0,0,1,https://www.chess.com/game/live/g1,white,alice,1500,1450,win,3\n" +
+				model.StartingFen + ",1,0,0,1,https://www.chess.com/game/live/g2,black,alice,1510,1520,draw,\n",
+		},
+		{
+			"positions without games",
+			PositionStats{Fen: model.StartingFen},
+			nil,
+			"fen,wins,losses,draws,total,games.url,games.color,games.opponent,games.rating,games.opponentRating,games.result,games.ply\n" +
+				model.StartingFen + ",0,0,0,0,,,,,,,\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, err := writeStatsAs(t, "text/csv", tt.response, tt.keys...)
+			if err != nil {
+				t.Fatalf("csv: %s", err)
+			}
+			if got := recorder.Body.String(); got != tt.csv {
+				t.Errorf("csv %q, expected %q", got, tt.csv)
+			}
+
+			// each ndjson object has the fields of the matching csv record
+			records, err := csv.NewReader(strings.NewReader(tt.csv)).ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder, err = writeStatsAs(t, "application/x-ndjson", tt.response, tt.keys...)
+			if err != nil {
+				t.Fatalf("ndjson: %s", err)
+			}
+			lines := strings.Split(strings.TrimSuffix(recorder.Body.String(), "\n"), "\n")
+			if len(lines) != len(records)-1 {
+				t.Fatalf("ndjson %q has %d objects, expected %d", recorder.Body, len(lines), len(records)-1)
+			}
+			for i, line := range lines {
+				var object map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &object); err != nil {
+					t.Fatalf("invalid ndjson object %q: %s", line, err)
+				}
+				if len(object) != len(records[0]) {
+					t.Errorf("ndjson object %q, expected the fields %v", line, records[0])
+				}
+				for j, column := range records[0] {
+					if field := csvField(object[column]); field != records[i+1][j] {
+						t.Errorf("ndjson object %q has %s %q, expected %q", line, column, field, records[i+1][j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWriteStatsNdjsonNulls(t *testing.T) {
+	recorder, err := writeStatsAs(t, "application/x-ndjson", map[string][]RatingBucket{"rapid": {}}, "timeClass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"bucket":null,"games":null,"last":null,"max":null,"min":null,"timeClass":"rapid"}` + "\n"
+	if got := recorder.Body.String(); got != expected {
+		t.Errorf("ndjson %q, expected %q", got, expected)
+	}
+}
+
+func TestResponseFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		format string
+	}{
+		{"", jsonContentType},
+		{"text/csv", csvContentType},
+		{"application/x-ndjson; charset=utf-8", ndjsonContentType},
+		{"text/csv, application/json", csvContentType},
+		{"application/json;q=0.5, text/csv", csvContentType},
+		{"text/csv;q=0.2, application/x-ndjson;q=0.8, application/json;q=0.5", ndjsonContentType},
+		{"text/html, */*;q=0.1", jsonContentType},
+		{"text/*", csvContentType},
+		{"application/json;q=0, */*", csvContentType},
+		{"text/csv;q=invalid, application/x-ndjson", ndjsonContentType},
+		{"text/html", ""},
+		{"application/json;q=0", ""},
+		{"*/*;q=0", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		req.Header.Set("Accept", tt.accept)
+		format, ok := responseFormat(req)
+		if ok != (tt.format != "") || ok && format != tt.format {
+			t.Errorf("Accept %q picked %q, %v, expected %q", tt.accept, format, ok, tt.format)
+		}
+	}
+
+	recorder, err := writeStatsAs(t, "text/html", map[string]GameStats{})
+	if err != nil || recorder.Code != http.StatusNotAcceptable {
+		t.Errorf("unacceptable Accept returned %d, %v, expected %d", recorder.Code, err, http.StatusNotAcceptable)
+	}
+}
+
+// responses with several fields that repeat have no flat shape, so they're only available as json
+func TestWriteStatsSeveralRepeatedFields(t *testing.T) {
+	responses := []interface{}{
+		struct {
+			Games []GameStats `json:"games"`
+			Moves []string    `json:"moves"`
+		}{},
+		map[string]struct {
+			Counts  map[string]int `json:"counts"`
+			Buckets []RatingBucket `json:"buckets"`
+		}{},
+		HeadToHead{},
+		Explorer{},
+	}
+
+	for i, response := range responses {
+		flat := i == len(responses)-1
+		if err := checkFlat(reflect.TypeOf(response)); flat != (err == nil) {
+			t.Errorf("checkFlat of %T returned %v", response, err)
+		}
+
+		for _, accept := range []string{csvContentType, ndjsonContentType} {
+			recorder, err := writeStatsAs(t, accept, response)
+			if err != nil {
+				t.Fatalf("writeStats of %T as %s: %s", response, accept, err)
+			}
+			expected := http.StatusNotAcceptable
+			if flat {
+				expected = http.StatusOK
+			}
+			if recorder.Code != expected {
+				t.Errorf("writeStats of %T as %s returned %d, expected %d", response, accept, recorder.Code, expected)
+			}
+		}
+
+		if recorder, err := writeStatsAs(t, jsonContentType, response); err != nil || recorder.Code != http.StatusOK {
+			t.Errorf("writeStats of %T as json returned %d, %v", response, recorder.Code, err)
+		}
+	}
+}
@@ -0,0 +1,505 @@
+package api
+
+import (
+	"backend/model"
+	"backend/types"
+	"backend/utils"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type gameSort struct {
+	// may use ?1, the username
+	column string
+}
+
+// gameSorts maps the sort query parameter of GetGames to the value games are ordered by. Values are never
+// NULL so they can be compared against a cursor.
+var gameSorts = map[string]gameSort{
+	"date":            {column: "COALESCE(g.end_time, 0)"},
+	"rating":          {column: "CASE WHEN g.white_player = ?1 THEN g.white_rating ELSE g.black_rating END"},
+	"opponent_rating": {column: "CASE WHEN g.white_player = ?1 THEN g.black_rating ELSE g.white_rating END"},
+}
+
+// gamesCursor is the position after the last game of a page. It carries the sort it was made for so it
+// can't be used with another.
+type gamesCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value int64  `json:"v"`
+	Id    string `json:"id"`
+}
+
+func (c gamesCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeGamesCursor(cursorStr string) (cursor gamesCursor, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursorStr)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil {
+		return cursor, fmt.Errorf("invalid cursor: %s", cursorStr)
+	}
+	return
+}
+
+type GameSummary struct {
+	Id             string `json:"id"`
+	Url            string `json:"url"`
+	Date           string `json:"date"`
+	TimeClass      string `json:"timeClass"`
+	TimeControl    string `json:"timeControl"`
+	Rated          bool   `json:"rated"`
+	Color          string `json:"color"`
+	Opponent       string `json:"opponent"`
+	Rating         int    `json:"rating"`
+	OpponentRating int    `json:"opponentRating"`
+	Result         string `json:"result"`
+	Termination    string `json:"termination"`
+	Eco            string `json:"eco,omitempty"`
+	Opening        string `json:"opening,omitempty"`
+}
+
+// gameSummaryColumns are the columns of games aliased as g that scanGameSummary reads, in order
+const gameSummaryColumns = `
+		g.id,
+		g.url,
+		g.end_time,
+		g.time_class,
+		g.time_control,
+		g.rated,
+		g.white_player,
+		g.black_player,
+		g.white_rating,
+		g.black_rating,
+		g.winner,
+		g.result,
+		g.eco,
+		g.opening_name`
+
+// scanGameSummary reads a row selected with gameSummaryColumns from the perspective of username, followed by
+// any extra columns into extra. Termination is how the loser lost, or why the game was drawn.
+func scanGameSummary(rows *sql.Rows, username string, extra ...interface{}) (game GameSummary, err error) {
+	var endTime sql.NullInt64
+	var rated sql.NullBool
+	var whitePlayer string
+	var blackPlayer string
+	var whiteRating int
+	var blackRating int
+	var winner sql.NullString
+	var eco sql.NullString
+	var openingName sql.NullString
+
+	dest := []interface{}{
+		&game.Id,
+		&game.Url,
+		&endTime,
+		&game.TimeClass,
+		&game.TimeControl,
+		&rated,
+		&whitePlayer,
+		&blackPlayer,
+		&whiteRating,
+		&blackRating,
+		&winner,
+		&game.Termination,
+		&eco,
+		&openingName,
+	}
+	if err = rows.Scan(append(dest, extra...)...); err != nil {
+		return
+	}
+
+	if endTime.Valid {
+		game.Date = time.Unix(endTime.Int64, 0).UTC().Format(time.RFC3339)
+	}
+	game.Rated = rated.Bool
+	game.Result = userResult(winner, username)
+	game.Eco = eco.String
+	game.Opening = openingName.String
+
+	game.Color = model.White.String()
+	game.Opponent = blackPlayer
+	game.Rating, game.OpponentRating = whiteRating, blackRating
+	if blackPlayer == username {
+		game.Color = model.Black.String()
+		game.Opponent = whitePlayer
+		game.Rating, game.OpponentRating = blackRating, whiteRating
+	}
+	return
+}
+
+type Games struct {
+	Games []GameSummary `json:"games"`
+
+	// pass as cursor to get the next page, empty on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// GetGames lists the user's games matching the stats filters, limit at a time. sort is date (the default),
+// rating or opponent_rating, the user's and their opponent's rating in the game, and order is asc or desc
+// (the default). Pages after the first are requested with the nextCursor of the previous page, which stays
+// correct while new games are inserted.
+func GetGames(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	if !req.URL.Query().Has("username") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	username := req.URL.Query().Get("username")
+
+	sortName := req.URL.Query().Get("sort")
+	if sortName == "" {
+		sortName = "date"
+	}
+	sort, ok := gameSorts[sortName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("invalid sort, expected date, rating or opponent_rating: %s", sortName), http.StatusBadRequest)
+		return
+	}
+
+	order := strings.ToUpper(req.URL.Query().Get("order"))
+	if order == "" {
+		order = "DESC"
+	}
+	if order != "ASC" && order != "DESC" {
+		http.Error(w, fmt.Sprintf("invalid order, expected asc or desc: %s", req.URL.Query().Get("order")), http.StatusBadRequest)
+		return
+	}
+
+	limit, err := pageLimit(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var cursor *gamesCursor
+	if cursorStr := req.URL.Query().Get("cursor"); cursorStr != "" {
+		decoded, err := decodeGamesCursor(cursorStr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if decoded.Sort != sortName || decoded.Order != order {
+			http.Error(w, "Cursor was made for a different sort or order", http.StatusBadRequest)
+			return
+		}
+		cursor = &decoded
+	}
+
+	if err := performSetupCheck(w, &state.SetupStatuses, username); err != nil {
+		fmt.Printf("Error getting games for user \"%s\": %s\n", username, err)
+		return
+	}
+
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	args := filter.Args(username)
+	param := filter.Next(2)
+
+	cursorCond := "1"
+	if cursor != nil {
+		comparison := "<"
+		if order == "ASC" {
+			comparison = ">"
+		}
+		cursorCond = fmt.Sprintf("(g.sort_value %s ?%d OR (g.sort_value = ?%d AND g.id %s ?%d))", comparison, param, param, comparison, param+1)
+		args = append(args, cursor.Value, cursor.Id)
+		param += 2
+	}
+	// one more than the page to know whether there is a next page
+	args = append(args, limit+1)
+
+	queryStr := fmt.Sprintf(`
+	SELECT%s,
+		g.sort_value
+	FROM (
+		SELECT
+			%s as sort_value,
+			g.*
+		FROM games g
+		WHERE %s
+	) g
+	WHERE %s
+	ORDER BY g.sort_value %s, g.id %s
+	LIMIT ?%d
+	`, gameSummaryColumns, sort.column, filter.Clause(2), cursorCond, order, order, param)
+
+	db := state.DBMap.Get(utils.Hash(username))
+	if db == nil {
+		fmt.Println("Error making games query: db not found")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	rows, err := db.Resource.Query(queryStr, args...)
+	if err != nil {
+		fmt.Printf("Error making games query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	response := Games{Games: []GameSummary{}}
+	var lastSortValue int64
+	for rows.Next() {
+		var sortValue int64
+		game, err := scanGameSummary(rows, username, &sortValue)
+		if err != nil {
+			fmt.Printf("Error parsing games query result: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if len(response.Games) == limit {
+			last := response.Games[len(response.Games)-1]
+			response.NextCursor = gamesCursor{Sort: sortName, Order: order, Value: lastSortValue, Id: last.Id}.encode()
+			break
+		}
+		response.Games = append(response.Games, game)
+		lastSortValue = sortValue
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Printf("Error encoding games query result: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+type GamePosition struct {
+	// null for positions of old games that couldn't be placed in the game, they come after the others
+	Ply        *int64 `json:"ply"`
+	Fen        string `json:"fen"`
+	SideToMove string `json:"sideToMove"`
+	Phase      string `json:"phase,omitempty"`
+
+	// move played from this position, empty for the final position
+	San string `json:"san,omitempty"`
+	Uci string `json:"uci,omitempty"`
+
+	// clock of the player who moved into this position, null when the pgn had no clocks
+	ClockMs     *int64 `json:"clockMs"`
+	TimeSpentMs *int64 `json:"timeSpentMs"`
+}
+
+type GameDetail struct {
+	GameSummary
+	StartTime     string         `json:"startTime,omitempty"`
+	WhitePlayer   string         `json:"whitePlayer"`
+	BlackPlayer   string         `json:"blackPlayer"`
+	WhiteRating   int            `json:"whiteRating"`
+	BlackRating   int            `json:"blackRating"`
+	WhiteUuid     string         `json:"whiteUuid,omitempty"`
+	BlackUuid     string         `json:"blackUuid,omitempty"`
+	WhiteAccuracy *float64       `json:"whiteAccuracy"`
+	BlackAccuracy *float64       `json:"blackAccuracy"`
+	Rules         string         `json:"rules,omitempty"`
+	Variant       string         `json:"variant"`
+	EcoUrl        string         `json:"ecoUrl,omitempty"`
+	Tcn           string         `json:"tcn,omitempty"`
+	Pgn           string         `json:"pgn"`
+	Moves         []string       `json:"moves"`
+	Positions     []GamePosition `json:"positions"`
+}
+
+func nullInt64Ptr(n sql.NullInt64) *int64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Int64
+}
+
+func nullFloat64Ptr(n sql.NullFloat64) *float64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Float64
+}
+
+// GetGame serves /games/{id}, everything stored about one of the user's games including its positions
+// in ply order
+func GetGame(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	if !req.URL.Query().Has("username") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	username := req.URL.Query().Get("username")
+
+	gameId := strings.TrimPrefix(req.URL.Path, "/games/")
+	if gameId == "" || strings.Contains(gameId, "/") {
+		http.Error(w, "Game id required", http.StatusBadRequest)
+		return
+	}
+
+	if err := performSetupCheck(w, &state.SetupStatuses, username); err != nil {
+		fmt.Printf("Error getting game for user \"%s\": %s\n", username, err)
+		return
+	}
+
+	gameQueryStr := fmt.Sprintf(`
+	SELECT%s,
+		g.white_player,
+		g.black_player,
+		g.white_rating,
+		g.black_rating,
+		g.start_time,
+		g.white_uuid,
+		g.black_uuid,
+		g.white_accuracy,
+		g.black_accuracy,
+		g.rules,
+		g.variant,
+		g.eco_url,
+		g.tcn,
+		g.pgn
+	FROM games g
+	WHERE g.id = ?
+	`, gameSummaryColumns)
+
+	positionsQueryStr := `
+	SELECT
+		p.ply,
+		f.fen,
+		p.side_to_move,
+		p.phase,
+		p.san,
+		p.uci,
+		p.clock_ms,
+		p.time_spent_ms
+	FROM game_positions p
+	JOIN fens f ON f.id = p.fen_id
+	WHERE p.game_id = ?
+	ORDER BY p.ply IS NULL, p.ply, p.rowid
+	`
+
+	db := state.DBMap.Get(utils.Hash(username))
+	if db == nil {
+		fmt.Println("Error making game query: db not found")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	gameRows, err := db.Resource.Query(gameQueryStr, gameId)
+	if err != nil {
+		fmt.Printf("Error making game query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer gameRows.Close()
+
+	if !gameRows.Next() {
+		if err := gameRows.Err(); err != nil {
+			fmt.Printf("Error making game query: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "Game not found", http.StatusNotFound)
+		return
+	}
+
+	var startTime sql.NullInt64
+	var whiteUuid, blackUuid, rules, ecoUrl, tcn, pgn sql.NullString
+	var whiteAccuracy, blackAccuracy sql.NullFloat64
+	var response GameDetail
+	response.GameSummary, err = scanGameSummary(
+		gameRows,
+		username,
+		&response.WhitePlayer,
+		&response.BlackPlayer,
+		&response.WhiteRating,
+		&response.BlackRating,
+		&startTime,
+		&whiteUuid,
+		&blackUuid,
+		&whiteAccuracy,
+		&blackAccuracy,
+		&rules,
+		&response.Variant,
+		&ecoUrl,
+		&tcn,
+		&pgn,
+	)
+	if err != nil {
+		fmt.Printf("Error parsing game query result: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	gameRows.Close()
+
+	if startTime.Valid {
+		response.StartTime = time.Unix(startTime.Int64, 0).UTC().Format(time.RFC3339)
+	}
+	response.WhiteUuid = whiteUuid.String
+	response.BlackUuid = blackUuid.String
+	response.WhiteAccuracy = nullFloat64Ptr(whiteAccuracy)
+	response.BlackAccuracy = nullFloat64Ptr(blackAccuracy)
+	response.Rules = rules.String
+	response.EcoUrl = ecoUrl.String
+	response.Tcn = tcn.String
+	response.Pgn = pgn.String
+	response.Moves = []string{}
+	response.Positions = []GamePosition{}
+
+	rows, err := db.Resource.Query(positionsQueryStr, gameId)
+	if err != nil {
+		fmt.Printf("Error making game positions query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var position GamePosition
+		var sideToMove, phase, san, uci sql.NullString
+		var ply, clockMs, timeSpentMs sql.NullInt64
+
+		if err := rows.Scan(
+			&ply,
+			&position.Fen,
+			&sideToMove,
+			&phase,
+			&san,
+			&uci,
+			&clockMs,
+			&timeSpentMs,
+		); err != nil {
+			fmt.Printf("Error parsing game positions query result: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		position.Ply = nullInt64Ptr(ply)
+		position.SideToMove = sideToMove.String
+		position.Phase = phase.String
+		position.San = san.String
+		position.Uci = uci.String
+		position.ClockMs = nullInt64Ptr(clockMs)
+		position.TimeSpentMs = nullInt64Ptr(timeSpentMs)
+		if position.San != "" {
+			response.Moves = append(response.Moves, position.San)
+		}
+		response.Positions = append(response.Positions, position)
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		fmt.Printf("Error encoding game query result: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
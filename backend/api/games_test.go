@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGetGame(t *testing.T) {
+	state := newTestState(t)
+
+	var game GameDetail
+	get(t, state, GetGame, "/games/g3", url.Values{"username": {"bob"}}, &game)
+
+	// players are reported as stored rather than rebuilt from the user's side of the game
+	if game.WhitePlayer != "carol" || game.BlackPlayer != "bob" || game.WhiteRating != 1600 || game.BlackRating != 1490 {
+		t.Errorf("game between %s %d and %s %d, expected carol 1600 and bob 1490", game.WhitePlayer, game.WhiteRating, game.BlackPlayer, game.BlackRating)
+	}
+	if game.Color != "black" || game.Opponent != "carol" || game.Result != "loss" || game.Termination != "timeout" || game.Eco != "C50" {
+		t.Errorf("game summary %+v, expected bob's loss on time as black in the italian", game.GameSummary)
+	}
+
+	if moves := strings.Join(game.Moves, " "); moves != "e4 e5 Nf3 Nc6 Bc4" || len(game.Positions) != 6 {
+		t.Fatalf("game has moves %s and %d positions, expected the 5 moves of the italian and 6 positions", moves, len(game.Positions))
+	}
+	// bob's 2... Nc6 took 110 of his remaining 120 seconds
+	if nc6 := game.Positions[4]; nc6.ClockMs == nil || *nc6.ClockMs != 10000 || nc6.TimeSpentMs == nil || *nc6.TimeSpentMs != 110000 {
+		t.Errorf("position after 2... Nc6 %+v, expected a 10s clock after spending 110s", nc6)
+	}
+	if start := game.Positions[0]; start.Ply == nil || *start.Ply != 0 || start.San != "e4" || start.ClockMs != nil {
+		t.Errorf("starting position %+v, expected ply 0 without a clock followed by e4", start)
+	}
+
+	recorder := httptest.NewRecorder()
+	GetGame(recorder, httptest.NewRequest(http.MethodGet, "/games/g5?username=bob", nil), state)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown game returned %d, expected %d", recorder.Code, http.StatusNotFound)
+	}
+}
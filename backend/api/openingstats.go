@@ -0,0 +1,100 @@
+package api
+
+import (
+	"backend/types"
+	"backend/utils"
+	"fmt"
+	"net/http"
+)
+
+type OpeningStats struct {
+	Eco       string `json:"eco"`
+	Name      string `json:"name"`
+	NumWins   int    `json:"wins"`
+	NumLosses int    `json:"losses"`
+	NumDraws  int    `json:"draws"`
+	Total     int    `json:"total"`
+}
+
+// GetOpeningStats gives the results of each opening the user played, keyed by time class then by the color
+// the user played, most played first. Games without a recognised opening are left out.
+func GetOpeningStats(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	if !req.URL.Query().Has("username") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	username := req.URL.Query().Get("username")
+
+	if err := performSetupCheck(w, &state.SetupStatuses, username); err != nil {
+		fmt.Printf("Error getting opening stats for user \"%s\": %s\n", username, err)
+		return
+	}
+
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queryStr := fmt.Sprintf(`
+	SELECT
+		g.time_class,
+		CASE WHEN g.white_player = ?1 THEN 'white' ELSE 'black' END as color,
+		g.eco,
+		g.opening_name,%s
+	FROM games g
+	WHERE g.eco IS NOT NULL AND %s
+	GROUP BY g.time_class, color, g.eco, g.opening_name
+	ORDER BY total DESC, g.eco, g.opening_name
+	`, gameStatsColumns, filter.Clause(2))
+
+	db := state.DBMap.Get(utils.Hash(username))
+	if db == nil {
+		fmt.Println("Error making opening stats query: db not found")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	rows, err := db.Resource.Query(queryStr, filter.Args(username)...)
+	if err != nil {
+		fmt.Printf("Error making opening stats query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	response := make(map[string]map[string][]OpeningStats)
+	for rows.Next() {
+		var timeClass string
+		var color string
+		var stats OpeningStats
+
+		if err := rows.Scan(
+			&timeClass,
+			&color,
+			&stats.Eco,
+			&stats.Name,
+			&stats.NumWins,
+			&stats.NumLosses,
+			&stats.NumDraws,
+			&stats.Total,
+		); err != nil {
+			fmt.Printf("Error parsing opening stats query result: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if response[timeClass] == nil {
+			response[timeClass] = make(map[string][]OpeningStats)
+		}
+		response[timeClass][color] = append(response[timeClass][color], stats)
+	}
+
+	if err := writeStats(w, req, response, "timeClass", "color"); err != nil {
+		fmt.Printf("Error encoding opening stats query result: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestGetOpeningStats(t *testing.T) {
+	state := newTestState(t)
+
+	var stats map[string]map[string][]OpeningStats
+	get(t, state, GetOpeningStats, "/stats/openings", url.Values{"username": {"bob"}}, &stats)
+
+	expected := map[string]map[string][]OpeningStats{
+		"blitz": {
+			"white": {{"C20", "King's Pawn Game: Wayward Queen Attack", 1, 0, 0, 1}},
+			"black": {{"C50", "Italian Game", 0, 1, 0, 1}},
+		},
+		"rapid": {
+			"black": {{"B90", "Sicilian Defense: Najdorf Variation", 0, 0, 1, 1}},
+		},
+	}
+	if fmt.Sprint(stats) != fmt.Sprint(expected) {
+		t.Errorf("opening stats %v, expected %v", stats, expected)
+	}
+}
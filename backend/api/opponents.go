@@ -0,0 +1,293 @@
+package api
+
+import (
+	"backend/types"
+	"backend/utils"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// opponentSorts maps the sort query parameter of GetOpponents to the columns it orders by
+var opponentSorts = map[string]string{
+	"games":       "total",
+	"wins":        "wins",
+	"losses":      "losses",
+	"draws":       "draws",
+	"rating_diff": "rating_diff",
+	"last_played": "last_played",
+	"name":        "opponent",
+}
+
+// opponentColumns summarise the games aliased as g against one opponent. The rating differential is the
+// opponent's rating minus the user's, averaged over the games.
+var opponentColumns = gameStatsColumns + `,
+		AVG(CASE WHEN g.white_player = ?1 THEN g.black_rating - g.white_rating ELSE g.white_rating - g.black_rating END) as rating_diff,
+		MAX(g.end_time) as last_played`
+
+type OpponentStats struct {
+	Name string `json:"name,omitempty"`
+	GameStats
+	AvgRatingDiff float64 `json:"ratingDiff"`
+	LastPlayed    string  `json:"lastPlayed"`
+}
+
+type Opponents struct {
+	Total     int             `json:"total"`
+	Opponents []OpponentStats `json:"opponents"`
+}
+
+type HeadToHead struct {
+	Name        string                   `json:"name"`
+	TimeClasses map[string]OpponentStats `json:"timeClasses"`
+	Games       []GameSummary            `json:"games"`
+}
+
+func formatLastPlayed(lastPlayed sql.NullInt64) string {
+	if !lastPlayed.Valid {
+		return ""
+	}
+	return time.Unix(lastPlayed.Int64, 0).UTC().Format(time.RFC3339)
+}
+
+// GetOpponents lists everyone the user played with their results against them, paged by limit and offset.
+// sort is one of games (the default), wins, losses, draws, rating_diff, last_played and name, and order is
+// asc or desc (the default). The stats filters apply.
+func GetOpponents(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	if !req.URL.Query().Has("username") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	username := req.URL.Query().Get("username")
+
+	sort := req.URL.Query().Get("sort")
+	if sort == "" {
+		sort = "games"
+	}
+	sortColumn, ok := opponentSorts[sort]
+	if !ok {
+		http.Error(w, fmt.Sprintf("invalid sort, expected games, wins, losses, draws, rating_diff, last_played or name: %s", sort), http.StatusBadRequest)
+		return
+	}
+
+	order := strings.ToUpper(req.URL.Query().Get("order"))
+	if order == "" {
+		order = "DESC"
+	}
+	if order != "ASC" && order != "DESC" {
+		http.Error(w, fmt.Sprintf("invalid order, expected asc or desc: %s", req.URL.Query().Get("order")), http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, err := pagination(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := performSetupCheck(w, &state.SetupStatuses, username); err != nil {
+		fmt.Printf("Error getting opponents for user \"%s\": %s\n", username, err)
+		return
+	}
+
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	countQueryStr := fmt.Sprintf(`
+	SELECT COUNT(DISTINCT CASE WHEN g.white_player = ?1 THEN g.black_player ELSE g.white_player END)
+	FROM games g
+	WHERE %s
+	`, filter.Clause(2))
+
+	queryStr := fmt.Sprintf(`
+	SELECT
+		CASE WHEN g.white_player = ?1 THEN g.black_player ELSE g.white_player END as opponent,%s
+	FROM games g
+	WHERE %s
+	GROUP BY opponent
+	ORDER BY %s %s, opponent
+	LIMIT ?%d OFFSET ?%d
+	`, opponentColumns, filter.Clause(2), sortColumn, order, filter.Next(2), filter.Next(2)+1)
+
+	db := state.DBMap.Get(utils.Hash(username))
+	if db == nil {
+		fmt.Println("Error making opponents query: db not found")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	response := Opponents{Opponents: []OpponentStats{}}
+	if err := db.Resource.QueryRow(countQueryStr, filter.Args(username)...).Scan(&response.Total); err != nil {
+		fmt.Printf("Error making opponents query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Resource.Query(queryStr, append(filter.Args(username), limit, offset)...)
+	if err != nil {
+		fmt.Printf("Error making opponents query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stats OpponentStats
+		var lastPlayed sql.NullInt64
+
+		if err := rows.Scan(
+			&stats.Name,
+			&stats.NumWins,
+			&stats.NumLosses,
+			&stats.NumDraws,
+			&stats.Total,
+			&stats.AvgRatingDiff,
+			&lastPlayed,
+		); err != nil {
+			fmt.Printf("Error parsing opponents query result: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		stats.LastPlayed = formatLastPlayed(lastPlayed)
+		response.Opponents = append(response.Opponents, stats)
+	}
+
+	if err := writeStats(w, req, response); err != nil {
+		fmt.Printf("Error encoding opponents query result: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetOpponent serves /opponents/{name}, the user's results against one opponent per time class and their
+// games against them newest first, paged by limit and offset. The stats filters apply. It's only available
+// as json, since both the time classes and the games repeat.
+func GetOpponent(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	if !req.URL.Query().Has("username") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	username := req.URL.Query().Get("username")
+
+	// usernames are stored lowercase
+	opponent := strings.ToLower(strings.TrimPrefix(req.URL.Path, "/opponents/"))
+	if opponent == "" || strings.Contains(opponent, "/") {
+		http.Error(w, "Opponent name required", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, err := pagination(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := performSetupCheck(w, &state.SetupStatuses, username); err != nil {
+		fmt.Printf("Error getting opponent for user \"%s\": %s\n", username, err)
+		return
+	}
+
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	statsQueryStr := fmt.Sprintf(`
+	SELECT
+		g.time_class,%s
+	FROM games g
+	WHERE (g.white_player = ?2 OR g.black_player = ?2) AND %s
+	GROUP BY g.time_class
+	`, opponentColumns, filter.Clause(3))
+
+	gamesQueryStr := fmt.Sprintf(`
+	SELECT%s
+	FROM games g
+	WHERE (g.white_player = ?1 OR g.black_player = ?1) AND %s
+	ORDER BY g.end_time DESC, g.id
+	LIMIT ?%d OFFSET ?%d
+	`, gameSummaryColumns, filter.Clause(2), filter.Next(2), filter.Next(2)+1)
+
+	db := state.DBMap.Get(utils.Hash(username))
+	if db == nil {
+		fmt.Println("Error making opponent query: db not found")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	rows, err := db.Resource.Query(statsQueryStr, filter.Args(username, opponent)...)
+	if err != nil {
+		fmt.Printf("Error making opponent query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	response := HeadToHead{
+		Name:        opponent,
+		TimeClasses: make(map[string]OpponentStats),
+		Games:       []GameSummary{},
+	}
+	for rows.Next() {
+		var timeClass string
+		var stats OpponentStats
+		var lastPlayed sql.NullInt64
+
+		if err := rows.Scan(
+			&timeClass,
+			&stats.NumWins,
+			&stats.NumLosses,
+			&stats.NumDraws,
+			&stats.Total,
+			&stats.AvgRatingDiff,
+			&lastPlayed,
+		); err != nil {
+			fmt.Printf("Error parsing opponent query result: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		stats.LastPlayed = formatLastPlayed(lastPlayed)
+		response.TimeClasses[timeClass] = stats
+	}
+
+	if len(response.TimeClasses) == 0 {
+		http.Error(w, "Opponent not found", http.StatusNotFound)
+		return
+	}
+
+	gameRows, err := db.Resource.Query(gamesQueryStr, append(filter.Args(opponent), limit, offset)...)
+	if err != nil {
+		fmt.Printf("Error making opponent query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer gameRows.Close()
+
+	for gameRows.Next() {
+		game, err := scanGameSummary(gameRows, username)
+		if err != nil {
+			fmt.Printf("Error parsing opponent query result: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		response.Games = append(response.Games, game)
+	}
+
+	if err := writeStats(w, req, response, "timeClass"); err != nil {
+		fmt.Printf("Error encoding opponent query result: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestGetOpponent(t *testing.T) {
+	state := newTestState(t)
+
+	// names are matched case insensitively
+	var headToHead HeadToHead
+	get(t, state, GetOpponent, "/opponents/Carol", url.Values{"username": {"bob"}, "variants": {"include"}}, &headToHead)
+
+	// carol, rated 110 then 130 above bob, beat him on time then by resignation in the chess960 game
+	expected := OpponentStats{GameStats: GameStats{NumLosses: 2, Total: 2}, AvgRatingDiff: 120, LastPlayed: "2023-12-06T22:00:00Z"}
+	if headToHead.Name != "carol" || len(headToHead.TimeClasses) != 1 || headToHead.TimeClasses["blitz"] != expected {
+		t.Errorf("head to head %+v, expected %+v in blitz against carol", headToHead.TimeClasses, expected)
+	}
+	if games := headToHead.Games; len(games) != 2 || games[0].Id != "g4" || games[1].Id != "g3" {
+		t.Errorf("listed games %+v, expected g4 then g3", games)
+	} else if games[1].Color != "black" || games[1].Opponent != "carol" || games[1].Result != "loss" || games[1].OpponentRating != 1600 {
+		t.Errorf("game %+v, expected bob's loss as black to carol rated 1600", games[1])
+	}
+
+	recorder := httptest.NewRecorder()
+	GetOpponent(recorder, httptest.NewRequest(http.MethodGet, "/opponents/dave?username=bob", nil), state)
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown opponent returned %d, expected %d", recorder.Code, http.StatusNotFound)
+	}
+
+	// the time classes and the games both repeat, so there's no csv of them
+	recorder = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/opponents/carol?username=bob", nil)
+	req.Header.Set("Accept", csvContentType)
+	GetOpponent(recorder, req, state)
+	if recorder.Code != http.StatusNotAcceptable {
+		t.Errorf("head to head as csv returned %d, expected %d", recorder.Code, http.StatusNotAcceptable)
+	}
+}
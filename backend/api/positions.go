@@ -0,0 +1,174 @@
+package api
+
+import (
+	"backend/model"
+	"backend/types"
+	"backend/utils"
+	"database/sql"
+	"fmt"
+	"net/http"
+)
+
+type PositionGame struct {
+	Url            string `json:"url"`
+	Color          string `json:"color"`
+	Opponent       string `json:"opponent"`
+	Rating         int    `json:"rating"`
+	OpponentRating int    `json:"opponentRating"`
+	Result         string `json:"result"`
+	Ply            *int64 `json:"ply"`
+}
+
+type PositionStats struct {
+	Fen       string         `json:"fen"`
+	NumWins   int            `json:"wins"`
+	NumLosses int            `json:"losses"`
+	NumDraws  int            `json:"draws"`
+	Total     int            `json:"total"`
+	Games     []PositionGame `json:"games"`
+}
+
+// userResult is the result of a game from the user's perspective
+func userResult(winner sql.NullString, username string) string {
+	if !winner.Valid {
+		return "draw"
+	}
+	if winner.String == username {
+		return "win"
+	}
+	return "loss"
+}
+
+// GetPositions finds the games that reached the fen query parameter. Move counters are ignored and an en passant
+// square only counts when the capture is possible, so any FEN of the same position matches. Games are listed
+// newest first, paged by limit and offset, with the ply the position was first reached at, which is null for
+// old games whose positions couldn't be placed.
+func GetPositions(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	if !req.URL.Query().Has("username") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	username := req.URL.Query().Get("username")
+
+	if !req.URL.Query().Has("fen") {
+		http.Error(w, "Fen required", http.StatusBadRequest)
+		return
+	}
+	board, err := model.ParseFen(req.URL.Query().Get("fen"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, err := pagination(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := performSetupCheck(w, &state.SetupStatuses, username); err != nil {
+		fmt.Printf("Error getting positions for user \"%s\": %s\n", username, err)
+		return
+	}
+
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	statsQueryStr := fmt.Sprintf(`
+	SELECT%s
+	FROM games g
+	WHERE g.id IN (SELECT game_id FROM game_positions WHERE fen_id = ?2) AND %s
+	`, gameStatsColumns, filter.Clause(3))
+
+	gamesQueryStr := fmt.Sprintf(`
+	SELECT
+		g.url,
+		g.white_player,
+		g.black_player,
+		g.white_rating,
+		g.black_rating,
+		g.winner,
+		MIN(p.ply) as ply
+	FROM game_positions p
+	JOIN games g ON g.id = p.game_id
+	WHERE p.fen_id = ?1 AND %s
+	GROUP BY g.id
+	ORDER BY g.end_time DESC, g.id
+	LIMIT ?%d OFFSET ?%d
+	`, filter.Clause(2), filter.Next(2), filter.Next(2)+1)
+
+	db := state.DBMap.Get(utils.Hash(username))
+	if db == nil {
+		fmt.Println("Error making positions query: db not found")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	response := PositionStats{
+		Fen:   board.PositionFen(),
+		Games: []PositionGame{},
+	}
+
+	err = db.Resource.QueryRow(statsQueryStr, filter.Args(username, board.Hash())...).Scan(
+		&response.NumWins,
+		&response.NumLosses,
+		&response.NumDraws,
+		&response.Total,
+	)
+	if err != nil {
+		fmt.Printf("Error making positions query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	rows, err := db.Resource.Query(gamesQueryStr, append(filter.Args(board.Hash()), limit, offset)...)
+	if err != nil {
+		fmt.Printf("Error making positions query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var url string
+		var whitePlayer string
+		var blackPlayer string
+		var whiteRating int
+		var blackRating int
+		var winner sql.NullString
+		var ply sql.NullInt64
+
+		if err := rows.Scan(&url, &whitePlayer, &blackPlayer, &whiteRating, &blackRating, &winner, &ply); err != nil {
+			fmt.Printf("Error parsing positions query result: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		game := PositionGame{
+			Url:            url,
+			Color:          model.White.String(),
+			Opponent:       blackPlayer,
+			Rating:         whiteRating,
+			OpponentRating: blackRating,
+			Result:         userResult(winner, username),
+			Ply:            nullInt64Ptr(ply),
+		}
+		if blackPlayer == username {
+			game.Color = model.Black.String()
+			game.Opponent = whitePlayer
+			game.Rating, game.OpponentRating = blackRating, whiteRating
+		}
+		response.Games = append(response.Games, game)
+	}
+
+	if err := writeStats(w, req, response); err != nil {
+		fmt.Printf("Error encoding positions query result: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
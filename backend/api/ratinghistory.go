@@ -0,0 +1,116 @@
+package api
+
+import (
+	"backend/types"
+	"backend/utils"
+	"fmt"
+	"net/http"
+)
+
+// sqlite expressions grouping end_time into buckets, weeks start on monday
+var bucketExpressions = map[string]string{
+	"day":   "date(g.end_time, 'unixepoch')",
+	"week":  "date(g.end_time, 'unixepoch', '-6 days', 'weekday 1')",
+	"month": "strftime('%Y-%m', g.end_time, 'unixepoch')",
+}
+
+type RatingBucket struct {
+	Bucket   string `json:"bucket"`
+	Last     int    `json:"last"`
+	Min      int    `json:"min"`
+	Max      int    `json:"max"`
+	NumGames int    `json:"games"`
+}
+
+// GetRatingHistory gives the user's rating after the games of each day, week or month (the bucket query
+// parameter, day by default) keyed by time class, oldest bucket first. The stats filters apply.
+func GetRatingHistory(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	if !req.URL.Query().Has("username") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	username := req.URL.Query().Get("username")
+
+	bucket := req.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = "day"
+	}
+	bucketExpr, ok := bucketExpressions[bucket]
+	if !ok {
+		http.Error(w, fmt.Sprintf("invalid bucket, expected day, week or month: %s", bucket), http.StatusBadRequest)
+		return
+	}
+
+	if err := performSetupCheck(w, &state.SetupStatuses, username); err != nil {
+		fmt.Printf("Error getting rating history for user \"%s\": %s\n", username, err)
+		return
+	}
+
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queryStr := fmt.Sprintf(`
+	SELECT
+		g.time_class,
+		%s as bucket,
+		CASE WHEN g.white_player = ?1 THEN g.white_rating ELSE g.black_rating END as rating
+	FROM games g
+	WHERE g.end_time IS NOT NULL AND %s
+	ORDER BY g.time_class, g.end_time
+	`, bucketExpr, filter.Clause(2))
+
+	db := state.DBMap.Get(utils.Hash(username))
+	if db == nil {
+		fmt.Println("Error making rating history query: db not found")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	rows, err := db.Resource.Query(queryStr, filter.Args(username)...)
+	if err != nil {
+		fmt.Printf("Error making rating history query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	response := make(map[string][]RatingBucket)
+	for rows.Next() {
+		var timeClass string
+		var bucket string
+		var rating int
+
+		if err := rows.Scan(&timeClass, &bucket, &rating); err != nil {
+			fmt.Printf("Error parsing rating history query result: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		// rows are ordered by time, so each game either extends the latest bucket or starts the next one
+		buckets := response[timeClass]
+		if len(buckets) == 0 || buckets[len(buckets)-1].Bucket != bucket {
+			buckets = append(buckets, RatingBucket{Bucket: bucket, Min: rating, Max: rating})
+		}
+		latest := &buckets[len(buckets)-1]
+		latest.Last = rating
+		latest.NumGames++
+		if rating < latest.Min {
+			latest.Min = rating
+		}
+		if rating > latest.Max {
+			latest.Max = rating
+		}
+		response[timeClass] = buckets
+	}
+
+	if err := writeStats(w, req, response, "timeClass"); err != nil {
+		fmt.Printf("Error encoding rating history query result: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
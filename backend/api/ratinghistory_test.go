@@ -0,0 +1,47 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestGetRatingHistory(t *testing.T) {
+	state := newTestState(t)
+
+	// bob's blitz games end on friday 2023-11-10, tuesday 2023-12-05 and wednesday 2023-12-06 rated 1500, 1490
+	// and 1480, his rapid game on monday 2023-11-20 rated 1510
+	rapid := func(bucket string) []RatingBucket {
+		return []RatingBucket{{bucket, 1510, 1510, 1510, 1}}
+	}
+	tests := []struct {
+		bucket   string
+		expected map[string][]RatingBucket
+	}{
+		{"day", map[string][]RatingBucket{
+			"blitz": {{"2023-11-10", 1500, 1500, 1500, 1}, {"2023-12-05", 1490, 1490, 1490, 1}, {"2023-12-06", 1480, 1480, 1480, 1}},
+			"rapid": rapid("2023-11-20"),
+		}},
+		// a game on a monday starts that monday's week
+		{"week", map[string][]RatingBucket{
+			"blitz": {{"2023-11-06", 1500, 1500, 1500, 1}, {"2023-12-04", 1480, 1480, 1490, 2}},
+			"rapid": rapid("2023-11-20"),
+		}},
+		{"month", map[string][]RatingBucket{
+			"blitz": {{"2023-11", 1500, 1500, 1500, 1}, {"2023-12", 1480, 1480, 1490, 2}},
+			"rapid": rapid("2023-11"),
+		}},
+	}
+
+	for _, tt := range tests {
+		var history map[string][]RatingBucket
+		get(t, state, GetRatingHistory, "/ratinghistory", url.Values{
+			"username": {"bob"},
+			"bucket":   {tt.bucket},
+			"variants": {"include"},
+		}, &history)
+		if fmt.Sprint(history) != fmt.Sprint(tt.expected) {
+			t.Errorf("%s rating history %v, expected %v", tt.bucket, history, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"backend/types"
+	"backend/utils"
+	"fmt"
+	"net/http"
+)
+
+type PeriodGameStats struct {
+	Bucket string `json:"bucket"`
+	GameStats
+}
+
+// GetResultsOverTime gives the same wins, losses and draws as GetGameStats for each day, week or month (the
+// bucket query parameter, month by default) keyed by time class, oldest bucket first. Buckets without games
+// are left out. The stats filters apply.
+func GetResultsOverTime(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	if !req.URL.Query().Has("username") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	username := req.URL.Query().Get("username")
+
+	bucket := req.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = "month"
+	}
+	bucketExpr, ok := bucketExpressions[bucket]
+	if !ok {
+		http.Error(w, fmt.Sprintf("invalid bucket, expected day, week or month: %s", bucket), http.StatusBadRequest)
+		return
+	}
+
+	if err := performSetupCheck(w, &state.SetupStatuses, username); err != nil {
+		fmt.Printf("Error getting results over time for user \"%s\": %s\n", username, err)
+		return
+	}
+
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queryStr := fmt.Sprintf(`
+	SELECT
+		g.time_class,
+		%s as bucket,%s
+	FROM games g
+	WHERE g.end_time IS NOT NULL AND %s
+	GROUP BY g.time_class, bucket
+	ORDER BY g.time_class, bucket
+	`, bucketExpr, gameStatsColumns, filter.Clause(2))
+
+	db := state.DBMap.Get(utils.Hash(username))
+	if db == nil {
+		fmt.Println("Error making results over time query: db not found")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	rows, err := db.Resource.Query(queryStr, filter.Args(username)...)
+	if err != nil {
+		fmt.Printf("Error making results over time query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	response := make(map[string][]PeriodGameStats)
+	for rows.Next() {
+		var timeClass string
+		var stats PeriodGameStats
+
+		if err := rows.Scan(
+			&timeClass,
+			&stats.Bucket,
+			&stats.NumWins,
+			&stats.NumLosses,
+			&stats.NumDraws,
+			&stats.Total,
+		); err != nil {
+			fmt.Printf("Error parsing results over time query result: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		response[timeClass] = append(response[timeClass], stats)
+	}
+
+	if err := writeStats(w, req, response, "timeClass"); err != nil {
+		fmt.Printf("Error encoding results over time query result: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func TestGetResultsOverTime(t *testing.T) {
+	state := newTestState(t)
+
+	// bob won his blitz game on friday 2023-11-10 and lost those on tuesday 2023-12-05 and wednesday 2023-12-06,
+	// his rapid game on monday 2023-11-20 was drawn
+	won, lost, drawn := GameStats{NumWins: 1, Total: 1}, GameStats{NumLosses: 1, Total: 1}, GameStats{NumDraws: 1, Total: 1}
+	tests := []struct {
+		bucket   string
+		expected map[string][]PeriodGameStats
+	}{
+		{"day", map[string][]PeriodGameStats{
+			"blitz": {{"2023-11-10", won}, {"2023-12-05", lost}, {"2023-12-06", lost}},
+			"rapid": {{"2023-11-20", drawn}},
+		}},
+		// a game on a monday starts that monday's week
+		{"week", map[string][]PeriodGameStats{
+			"blitz": {{"2023-11-06", won}, {"2023-12-04", GameStats{NumLosses: 2, Total: 2}}},
+			"rapid": {{"2023-11-20", drawn}},
+		}},
+		// months are the default
+		{"", map[string][]PeriodGameStats{
+			"blitz": {{"2023-11", won}, {"2023-12", GameStats{NumLosses: 2, Total: 2}}},
+			"rapid": {{"2023-11", drawn}},
+		}},
+	}
+
+	for _, tt := range tests {
+		query := url.Values{"username": {"bob"}, "variants": {"include"}}
+		if tt.bucket != "" {
+			query.Set("bucket", tt.bucket)
+		}
+		var results map[string][]PeriodGameStats
+		get(t, state, GetResultsOverTime, "/resultsovertime", query, &results)
+		if fmt.Sprint(results) != fmt.Sprint(tt.expected) {
+			t.Errorf("results over time by %q %v, expected %v", tt.bucket, results, tt.expected)
+		}
+	}
+}
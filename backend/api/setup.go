@@ -4,6 +4,7 @@ import (
 	"backend/model"
 	"backend/types"
 	"backend/utils"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -13,8 +14,14 @@ import (
 	"time"
 )
 
+// games parsed ahead of the db inserts before the downloads are paused
+const gameBufferSize = 1024
+
 type SetupReqBody struct {
 	Username string `json:"username"`
+
+	// re-downloads archives that failed during a previous setup with a partial result
+	RetryFailed bool `json:"retryFailed"`
 }
 
 type SetupResp struct {
@@ -78,67 +85,104 @@ func handleSetupError(requestId string, err error, setupStatuses *types.SetupSta
 	(*setupStatuses.Resource)[requestId] = types.SetupStatusFailed
 }
 
-func printInsertStats(stats model.InsertStatistics) {
+func PrintInsertStats(stats model.InsertStatistics) {
 	fmt.Printf("  %d games inserted\n", stats.NumGamesInserted)
 	fmt.Printf("  %d games failed to insert\n", stats.NumGameInsertErrors)
 	fmt.Printf("  %d positions inserted\n", stats.NumPositionsInserted)
 	fmt.Printf("  %d positions failed to insert\n", stats.NumPositionInsertErrors)
 }
 
+func completedSetupStatus(gamesResult model.GamesResult) types.SetupStatus {
+	failed := gamesResult.Failed()
+	if len(failed) == 0 {
+		return types.SetupStatusComplete
+	}
+
+	fmt.Printf("%d / %d archives failed to download:\n", len(failed), len(gamesResult.Archives))
+	for _, archive := range failed {
+		fmt.Printf("  %s: %s\n", archive.Url, archive.Err)
+	}
+	return types.SetupStatusPartial
+}
+
+// ingestArchives downloads archives and inserts their games into db while the downloads are still running
+func ingestArchives(
+	db *sql.DB,
+	requestId string,
+	username string,
+	archives []string,
+	state *types.ServerState,
+) (gamesResult model.GamesResult, insertStats model.InsertStatistics, err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	games := make(chan model.Game, gameBufferSize)
+	gamesResultCh := make(chan model.GamesResult, 1)
+	go func() {
+		gamesResultCh <- state.ChessCom.GetAllGames(ctx, archives, games)
+	}()
+
+	insertStats, err = model.InsertUserData(db, requestId, username, games, archives)
+	if err != nil {
+		// stop the downloads, nothing is reading the games anymore
+		cancel()
+	}
+	gamesResult = <-gamesResultCh
+
+	return
+}
+
 func fullSetup(requestId string, username string, state *types.ServerState) {
 	setupStart := time.Now()
 
-	dbFilename := fmt.Sprintf("file:%s.db?_journal_mode=WAL&_synchronous=NORMAL", requestId)
-	db, err := sql.Open("sqlite3", dbFilename)
+	db, err := model.OpenUserDb(requestId)
 	if err != nil {
-		handleSetupError(requestId, fmt.Errorf("error opening db: %w", err), &state.SetupStatuses)
+		handleSetupError(requestId, err, &state.SetupStatuses)
 		return
 	}
 
-	state.DBMap[requestId] = types.NewLockedDB(db)
-
-	model.CreateTables(db)
+	state.DBMap.Set(requestId, types.NewLockedDB(db))
 
 	fmt.Println("User data request started:")
 	requestGamesStart := time.Now()
 
-	archives, err := model.ListArchives(username)
+	archives, err := state.ChessCom.ListArchives(username)
 	if err != nil {
 		handleSetupError(requestId, fmt.Errorf("error opening db: %w", err), &state.SetupStatuses)
 		return
 	}
 
-	allGames := model.GetAllGames(archives)
-	duration := time.Since(requestGamesStart)
-	fmt.Printf("%d games received in %v!\n", len(allGames), duration)
-
-	fmt.Println("Inserting into db started:")
-	insertStart := time.Now()
-	insertStats, err := model.InsertUserData(db, requestId, username, allGames, archives)
+	gamesResult, insertStats, err := ingestArchives(db, requestId, username, archives, state)
 	if err != nil {
 		handleSetupError(requestId, fmt.Errorf("error inserting user data: %w", err), &state.SetupStatuses)
 		return
 	}
 
-	duration = time.Since(insertStart)
-	fmt.Printf("Inserted user data in %v\n", duration)
-	printInsertStats(insertStats)
+	if err := model.RecordArchiveResults(db, gamesResult.Archives); err != nil {
+		handleSetupError(requestId, fmt.Errorf("error recording archive results: %w", err), &state.SetupStatuses)
+		return
+	}
+
+	duration := time.Since(requestGamesStart)
+	fmt.Printf("%d games received and inserted in %v\n", gamesResult.NumGames(), duration)
+	PrintInsertStats(insertStats)
 	fmt.Printf("Downloaded and saved user data in %v\n", time.Since(setupStart))
 
+	status := completedSetupStatus(gamesResult)
 	state.SetupStatuses.Mu.Lock()
 	defer state.SetupStatuses.Mu.Unlock()
-	(*state.SetupStatuses.Resource)[requestId] = types.SetupStatusComplete
+	(*state.SetupStatuses.Resource)[requestId] = status
 }
 
 func updateExistingUser(requestId string, username string, state *types.ServerState) {
-	db, exists := state.DBMap[requestId]
-	if !exists {
+	db := state.DBMap.Get(requestId)
+	if db == nil {
 		fmt.Printf("Error updating existing user: db for user %s doesn't exist\n", requestId)
 	}
 	db.Mu.Lock()
 	defer db.Mu.Unlock()
 
-	allArchives, err := model.ListArchives(username)
+	allArchives, err := state.ChessCom.ListArchives(username)
 	if err != nil {
 		handleSetupError(requestId, fmt.Errorf("error listing archives: %w", err), &state.SetupStatuses)
 		return
@@ -157,6 +201,7 @@ func updateExistingUser(requestId string, username string, state *types.ServerSt
 	}
 
 	archivesToUpdate := []string{}
+	isQueued := make(map[string]bool)
 	for _, archive := range allArchives {
 		date, err := archiveToLogicalTimestamp(archive)
 		if err != nil {
@@ -166,21 +211,39 @@ func updateExistingUser(requestId string, username string, state *types.ServerSt
 
 		if date >= latestDate {
 			archivesToUpdate = append(archivesToUpdate, archive)
+			isQueued[archive] = true
 		}
 	}
 
-	games := model.GetAllGames(archivesToUpdate)
-	insertStats, err := model.InsertUserData(db.Resource, requestId, username, games, archivesToUpdate)
+	// archives that failed previously are older than the latest stored one, so retry them explicitly
+	failedArchives, err := model.GetFailedArchives(db.Resource)
+	if err != nil {
+		handleSetupError(requestId, fmt.Errorf("error getting failed archives: %w", err), &state.SetupStatuses)
+		return
+	}
+	for _, archive := range failedArchives {
+		if !isQueued[archive] {
+			archivesToUpdate = append([]string{archive}, archivesToUpdate...)
+		}
+	}
+
+	gamesResult, insertStats, err := ingestArchives(db.Resource, requestId, username, archivesToUpdate, state)
 	if err != nil {
 		err = fmt.Errorf("error inserting user data: %w", err)
 		handleSetupError(requestId, err, &state.SetupStatuses)
 		return
 	}
 
-	printInsertStats(insertStats)
+	if err := model.RecordArchiveResults(db.Resource, gamesResult.Archives); err != nil {
+		handleSetupError(requestId, fmt.Errorf("error recording archive results: %w", err), &state.SetupStatuses)
+		return
+	}
+
+	PrintInsertStats(insertStats)
+	status := completedSetupStatus(gamesResult)
 	state.SetupStatuses.Mu.Lock()
 	defer state.SetupStatuses.Mu.Unlock()
-	(*state.SetupStatuses.Resource)[requestId] = types.SetupStatusComplete
+	(*state.SetupStatuses.Resource)[requestId] = status
 }
 
 func Setup(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
@@ -198,7 +261,8 @@ func Setup(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
 	currentStatus := (*state.SetupStatuses.Resource)[requestId]
 
 	// if the setup is pending, we need to update the existing data instead of doing a full setup
-	if currentStatus == types.SetupStatusPending {
+	retryPartial := currentStatus == types.SetupStatusPartial && body.RetryFailed
+	if currentStatus == types.SetupStatusPending || retryPartial {
 		(*state.SetupStatuses.Resource)[requestId] = types.SetupStatusUpdating
 		jsonEncoder.Encode(SetupResp{
 			Id:     requestId,
@@ -3,7 +3,6 @@ package api
 import (
 	"backend/types"
 	"backend/utils"
-	"encoding/json"
 	"fmt"
 	"net/http"
 )
@@ -33,6 +32,14 @@ type DrawStats struct {
 	Total                     int `json:"total"`
 }
 
+// gameStatsColumns counts the wins, losses and draws of the games aliased as g from the perspective of the
+// user in ?1. Rows where g is NULL, from a LEFT JOIN, count as nothing.
+const gameStatsColumns = `
+		COALESCE(SUM(CASE WHEN g.winner = ?1 THEN 1 ELSE NULL END), 0) as wins,
+		COALESCE(SUM(CASE WHEN g.winner IS NOT NULL AND g.winner != ?1 THEN 1 ELSE NULL END), 0) as losses,
+		COALESCE(SUM(CASE WHEN g.id IS NOT NULL AND g.winner IS NULL THEN 1 ELSE NULL END), 0) as draws,
+		COUNT(g.id) as total`
+
 func GetGameStats(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
 	if !req.URL.Query().Has("username") {
 		http.Error(w, "Username required", http.StatusBadRequest)
@@ -45,22 +52,24 @@ func GetGameStats(w http.ResponseWriter, req *http.Request, state *types.ServerS
 		return
 	}
 
-	queryStr := `
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queryStr := fmt.Sprintf(`
 	SELECT 
-		tc.time_class, 
-		COALESCE(SUM(CASE WHEN g.winner = $1 THEN 1 ELSE NULL END), 0) as wins, 
-		COALESCE(SUM(CASE WHEN g.winner IS NOT NULL AND g.winner != $1 THEN 1 ELSE NULL END), 0) as losses,
-		COALESCE(SUM(CASE WHEN g.winner IS NULL THEN 1 ELSE NULL END), 0) as draws,
-		COUNT(*) as total
+		tc.time_class,%s
 	FROM (
 		SELECT DISTINCT time_class
 		FROM games
 	) tc
-  LEFT JOIN games g ON tc.time_class = g.time_class
+  LEFT JOIN games g ON tc.time_class = g.time_class AND %s
   GROUP BY tc.time_class
-	`
+	`, gameStatsColumns, filter.Clause(2))
 
-	db := state.DBMap[utils.Hash(username)]
+	db := state.DBMap.Get(utils.Hash(username))
 	if db == nil {
 		fmt.Println("Error making game stats query: db not found")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -69,7 +78,7 @@ func GetGameStats(w http.ResponseWriter, req *http.Request, state *types.ServerS
 	db.Mu.Lock()
 	defer db.Mu.Unlock()
 
-	rows, err := db.Resource.Query(queryStr, username)
+	rows, err := db.Resource.Query(queryStr, filter.Args(username)...)
 	if err != nil {
 		fmt.Printf("Error making game stats query: %s\n", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -99,7 +108,7 @@ func GetGameStats(w http.ResponseWriter, req *http.Request, state *types.ServerS
 		}
 	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	if err := writeStats(w, req, response, "timeClass"); err != nil {
 		fmt.Printf("Error encoding game stats query result: %s\n", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -118,23 +127,29 @@ func GetWinStats(w http.ResponseWriter, req *http.Request, state *types.ServerSt
 		return
 	}
 
-	queryStr := `
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queryStr := fmt.Sprintf(`
   SELECT
     tc.time_class,
     COALESCE(SUM(CASE WHEN g.result = 'resigned' THEN 1 ELSE NULL END), 0) as resigns,
     COALESCE(SUM(CASE WHEN g.result = 'checkmated' THEN 1 ELSE NULL END), 0) as checkmates,
     COALESCE(SUM(CASE WHEN g.result = 'abandoned' THEN 1 ELSE NULL END), 0) as abandons,
     COALESCE(SUM(CASE WHEN g.result = 'timeout' THEN 1 ELSE NULL END), 0) as timeouts,
-		COUNT(*) as total
+		COUNT(g.id) as total
   FROM (
     SELECT DISTINCT time_class FROM games
   ) tc 
   LEFT JOIN
-    games g ON tc.time_class = g.time_class AND g.winner = $1
+    games g ON tc.time_class = g.time_class AND g.winner = ?1 AND %s
   GROUP BY tc.time_class
-  `
+  `, filter.Clause(2))
 
-	db := state.DBMap[utils.Hash(username)]
+	db := state.DBMap.Get(utils.Hash(username))
 	if db == nil {
 		fmt.Println("Error making win stats query: db not found")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -143,7 +158,7 @@ func GetWinStats(w http.ResponseWriter, req *http.Request, state *types.ServerSt
 	db.Mu.Lock()
 	defer db.Mu.Unlock()
 
-	rows, err := db.Resource.Query(queryStr, username)
+	rows, err := db.Resource.Query(queryStr, filter.Args(username)...)
 	if err != nil {
 		fmt.Printf("Error making win stats query: %s\n", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -175,7 +190,7 @@ func GetWinStats(w http.ResponseWriter, req *http.Request, state *types.ServerSt
 		}
 	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	if err := writeStats(w, req, response, "timeClass"); err != nil {
 		fmt.Printf("Error encoding win stats query result: %s\n", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -194,23 +209,29 @@ func GetLossStats(w http.ResponseWriter, req *http.Request, state *types.ServerS
 		return
 	}
 
-	queryStr := `
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queryStr := fmt.Sprintf(`
   SELECT
     tc.time_class,
     COALESCE(SUM(CASE WHEN g.result = 'resigned' THEN 1 ELSE NULL END), 0) as resigns,
     COALESCE(SUM(CASE WHEN g.result = 'checkmated' THEN 1 ELSE NULL END), 0) as checkmates,
     COALESCE(SUM(CASE WHEN g.result = 'abandoned' THEN 1 ELSE NULL END), 0) as abandons,
     COALESCE(SUM(CASE WHEN g.result = 'timeout' THEN 1 ELSE NULL END), 0) as timeouts,
-		COUNT(*) as total
+		COUNT(g.id) as total
   FROM (
     SELECT DISTINCT time_class FROM games
   ) tc 
   LEFT JOIN
-    games g ON tc.time_class = g.time_class AND g.winner != $1
+    games g ON tc.time_class = g.time_class AND g.winner != ?1 AND %s
   GROUP BY tc.time_class
-  `
+  `, filter.Clause(2))
 
-	db := state.DBMap[utils.Hash(username)]
+	db := state.DBMap.Get(utils.Hash(username))
 	if db == nil {
 		fmt.Println("Error making loss stats query: db not found")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -219,7 +240,7 @@ func GetLossStats(w http.ResponseWriter, req *http.Request, state *types.ServerS
 	db.Mu.Lock()
 	defer db.Mu.Unlock()
 
-	rows, err := db.Resource.Query(queryStr, username)
+	rows, err := db.Resource.Query(queryStr, filter.Args(username)...)
 	if err != nil {
 		fmt.Printf("Error making loss stats query: %s\n", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -251,7 +272,7 @@ func GetLossStats(w http.ResponseWriter, req *http.Request, state *types.ServerS
 		}
 	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	if err := writeStats(w, req, response, "timeClass"); err != nil {
 		fmt.Printf("Error encoding loss stats query result: %s\n", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
@@ -270,7 +291,13 @@ func GetDrawStats(w http.ResponseWriter, req *http.Request, state *types.ServerS
 		return
 	}
 
-	queryStr := `
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	queryStr := fmt.Sprintf(`
   SELECT
     tc.time_class,
     COALESCE(SUM(CASE WHEN g.result = 'repetition' THEN 1 ELSE NULL END), 0) as repetitions,
@@ -279,16 +306,16 @@ func GetDrawStats(w http.ResponseWriter, req *http.Request, state *types.ServerS
     COALESCE(SUM(CASE WHEN g.result = 'stalemate' THEN 1 ELSE NULL END), 0) as stalemates,
     COALESCE(SUM(CASE WHEN g.result = 'agreed' THEN 1 ELSE NULL END), 0) as agrees,
     COALESCE(SUM(CASE WHEN g.result = '50move' THEN 1 ELSE NULL END), 0) as fiftyMoveRules,
-		COUNT(*) as total
+		COUNT(g.id) as total
   FROM (
     SELECT DISTINCT time_class FROM games
   ) tc 
   LEFT JOIN
-    games g ON tc.time_class = g.time_class AND g.winner IS NULL
+    games g ON tc.time_class = g.time_class AND g.winner IS NULL AND %s
   GROUP BY tc.time_class
-  `
+  `, filter.Clause(1))
 
-	db := state.DBMap[utils.Hash(username)]
+	db := state.DBMap.Get(utils.Hash(username))
 	if db == nil {
 		fmt.Println("Error making draw stats query: db not found")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -297,7 +324,7 @@ func GetDrawStats(w http.ResponseWriter, req *http.Request, state *types.ServerS
 	db.Mu.Lock()
 	defer db.Mu.Unlock()
 
-	rows, err := db.Resource.Query(queryStr)
+	rows, err := db.Resource.Query(queryStr, filter.Args()...)
 	if err != nil {
 		fmt.Printf("Error making draw stats query: %s\n", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -342,7 +369,7 @@ func GetDrawStats(w http.ResponseWriter, req *http.Request, state *types.ServerS
 		}
 	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	if err := writeStats(w, req, response, "timeClass"); err != nil {
 		fmt.Printf("Error encoding draw stats query result: %s\n", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
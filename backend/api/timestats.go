@@ -0,0 +1,216 @@
+package api
+
+import (
+	"backend/model"
+	"backend/types"
+	"backend/utils"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// a user is in time trouble once their clock drops below this fraction of the starting clock
+	timeTroubleFraction = 0.1
+
+	// material lead, in pawns, the user needs in the final position for a timeout loss to count as winning
+	winningMaterialLead = 3
+)
+
+type TimeStats struct {
+	AvgOpeningMoveSeconds    float64 `json:"avgOpeningMoveSeconds"`
+	AvgMiddlegameMoveSeconds float64 `json:"avgMiddlegameMoveSeconds"`
+	AvgEndgameMoveSeconds    float64 `json:"avgEndgameMoveSeconds"`
+	NumLostOnTime            int     `json:"lostOnTime"`
+	NumLostOnTimeWinning     int     `json:"lostOnTimeWinning"`
+	LostOnTimeWinningPct     float64 `json:"lostOnTimeWinningPct"`
+	NumTimeTrouble           int     `json:"timeTrouble"`
+	TimeTroublePct           float64 `json:"timeTroublePct"`
+	NumWithClocks            int     `json:"withClocks"`
+	Total                    int     `json:"total"`
+}
+
+func percentage(count int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(count) * 100 / float64(total)
+}
+
+// GetTimeStats reports, per time class, how long the user thinks per move in each phase of the game,
+// how often they lose on time while ahead on material and how often they get into time trouble.
+// Only games with %clk comments contribute to the clock based numbers, so the time trouble percentage is out
+// of the games with clocks rather than all of them.
+func GetTimeStats(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+	if !req.URL.Query().Has("username") {
+		http.Error(w, "Username required", http.StatusBadRequest)
+		return
+	}
+	username := req.URL.Query().Get("username")
+
+	if err := performSetupCheck(w, &state.SetupStatuses, username); err != nil {
+		fmt.Printf("Error getting time stats for user \"%s\": %s\n", username, err)
+		return
+	}
+
+	filter, err := parseGameFilter(req, username)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the user's moves are the ones that hand the move over to the opponent
+	avgQueryStr := fmt.Sprintf(`
+	SELECT
+		g.time_class,
+		p.phase,
+		AVG(p.time_spent_ms) as avg_time_spent_ms
+	FROM games g
+	JOIN game_positions p ON p.game_id = g.id
+	WHERE p.time_spent_ms IS NOT NULL
+		AND p.side_to_move = CASE WHEN g.white_player = ?1 THEN 'black' ELSE 'white' END
+		AND %s
+	GROUP BY g.time_class, p.phase
+	`, filter.Clause(2))
+
+	gamesQueryStr := fmt.Sprintf(`
+	SELECT
+		tc.time_class,
+		g.time_control,
+		g.white_player = ?1 as is_white,
+		g.winner IS NOT NULL AND g.winner != ?1 AND g.result = 'timeout' as lost_on_time,
+		(
+			SELECT MIN(p.clock_ms)
+			FROM game_positions p
+			WHERE p.game_id = g.id
+				AND p.side_to_move = CASE WHEN g.white_player = ?1 THEN 'black' ELSE 'white' END
+		) as min_clock_ms,
+		(
+			SELECT f.fen
+			FROM game_positions p
+			JOIN fens f ON f.id = p.fen_id
+			WHERE p.game_id = g.id
+			ORDER BY p.ply IS NULL DESC, p.ply DESC, p.rowid DESC
+			LIMIT 1
+		) as final_fen
+	FROM (
+		SELECT DISTINCT time_class
+		FROM games
+	) tc
+	LEFT JOIN games g ON tc.time_class = g.time_class AND %s
+	`, filter.Clause(2))
+
+	db := state.DBMap.Get(utils.Hash(username))
+	if db == nil {
+		fmt.Println("Error making time stats query: db not found")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	db.Mu.Lock()
+	defer db.Mu.Unlock()
+
+	response := make(map[string]TimeStats)
+
+	rows, err := db.Resource.Query(gamesQueryStr, filter.Args(username)...)
+	if err != nil {
+		fmt.Printf("Error making time stats query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var timeClass string
+		var timeControl sql.NullString
+		var isWhite sql.NullBool
+		var lostOnTime sql.NullBool
+		var minClockMs sql.NullInt64
+		var finalFen sql.NullString
+
+		if err := rows.Scan(&timeClass, &timeControl, &isWhite, &lostOnTime, &minClockMs, &finalFen); err != nil {
+			fmt.Printf("Error parsing time stats query result: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		stats := response[timeClass]
+		if !timeControl.Valid {
+			// time class without any games matching the filters
+			response[timeClass] = stats
+			continue
+		}
+		stats.Total++
+
+		if lostOnTime.Bool {
+			stats.NumLostOnTime++
+			if b, err := model.ParseFen(finalFen.String); err == nil {
+				user, opponent := model.White, model.Black
+				if !isWhite.Bool {
+					user, opponent = model.Black, model.White
+				}
+				if b.Material(user)-b.Material(opponent) >= winningMaterialLead {
+					stats.NumLostOnTimeWinning++
+				}
+			}
+		}
+
+		if base, _, ok := model.ParseTimeControl(timeControl.String); ok && minClockMs.Valid {
+			stats.NumWithClocks++
+			minClock := time.Duration(minClockMs.Int64) * time.Millisecond
+			if float64(minClock) < float64(base)*timeTroubleFraction {
+				stats.NumTimeTrouble++
+			}
+		}
+
+		response[timeClass] = stats
+	}
+	if err := rows.Err(); err != nil {
+		fmt.Printf("Error reading time stats query result: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	avgRows, err := db.Resource.Query(avgQueryStr, filter.Args(username)...)
+	if err != nil {
+		fmt.Printf("Error making time stats query: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer avgRows.Close()
+
+	for avgRows.Next() {
+		var timeClass string
+		var phase sql.NullString
+		var avgTimeSpentMs float64
+
+		if err := avgRows.Scan(&timeClass, &phase, &avgTimeSpentMs); err != nil {
+			fmt.Printf("Error parsing time stats query result: %s\n", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		stats := response[timeClass]
+		switch phase.String {
+		case model.PhaseOpening:
+			stats.AvgOpeningMoveSeconds = avgTimeSpentMs / 1000
+		case model.PhaseMiddlegame:
+			stats.AvgMiddlegameMoveSeconds = avgTimeSpentMs / 1000
+		case model.PhaseEndgame:
+			stats.AvgEndgameMoveSeconds = avgTimeSpentMs / 1000
+		}
+		response[timeClass] = stats
+	}
+
+	for timeClass, stats := range response {
+		stats.LostOnTimeWinningPct = percentage(stats.NumLostOnTimeWinning, stats.Total)
+		stats.TimeTroublePct = percentage(stats.NumTimeTrouble, stats.NumWithClocks)
+		response[timeClass] = stats
+	}
+
+	if err := writeStats(w, req, response, "timeClass"); err != nil {
+		fmt.Printf("Error encoding time stats query result: %s\n", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
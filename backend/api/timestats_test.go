@@ -0,0 +1,30 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestGetTimeStats(t *testing.T) {
+	state := newTestState(t)
+
+	var stats map[string]TimeStats
+	get(t, state, GetTimeStats, "/stats/time", url.Values{"username": {"bob"}}, &stats)
+
+	// bob spends 2, 3, 4 and 2 seconds on his moves of the scholar's mate and 60 then 110 on the italian,
+	// where he drops to 10 seconds and loses on time without a material lead
+	expected := TimeStats{
+		AvgOpeningMoveSeconds: 181.0 / 6,
+		NumLostOnTime:         1,
+		NumTimeTrouble:        1,
+		TimeTroublePct:        50,
+		NumWithClocks:         2,
+		Total:                 2,
+	}
+	if stats["blitz"] != expected {
+		t.Errorf("blitz time stats %+v, expected %+v", stats["blitz"], expected)
+	}
+	if stats["rapid"] != (TimeStats{Total: 1}) {
+		t.Errorf("rapid time stats %+v, expected one game without clocks", stats["rapid"])
+	}
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"backend/types"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"regexp"
@@ -19,6 +20,57 @@ func MakeHandler(
 	}
 }
 
+// MakeAdminHandler only lets requests through that carry "Authorization: Bearer <token>".
+// Every request is rejected when token is empty.
+func MakeAdminHandler(
+	state *types.ServerState,
+	token string,
+	handler func(http.ResponseWriter, *http.Request, *types.ServerState),
+) http.HandlerFunc {
+	return MakeHandler(state, func(w http.ResponseWriter, req *http.Request, state *types.ServerState) {
+		provided := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, req, state)
+	})
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// pageLimit reads the limit query parameter used by the endpoints that list games
+func pageLimit(req *http.Request) (limit int, err error) {
+	limit = defaultPageSize
+	if limitStr := req.URL.Query().Get("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return 0, fmt.Errorf("invalid limit, expected 1 to %d: %s", maxPageSize, limitStr)
+		}
+	}
+	return limit, nil
+}
+
+// pagination reads the limit and offset query parameters
+func pagination(req *http.Request) (limit int, offset int, err error) {
+	limit, err = pageLimit(req)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if offsetStr := req.URL.Query().Get("offset"); offsetStr != "" {
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset: %s", offsetStr)
+		}
+	}
+
+	return limit, offset, nil
+}
+
 func archiveToLogicalTimestamp(archive string) (date int, err error) {
 	regex, err := regexp.Compile("[0-9]{4}/[0-9]{2}$")
 	if err != nil {
@@ -0,0 +1,248 @@
+// Package chesscomtest fakes the chess.com published data api, and the games in its archives, for tests
+package chesscomtest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	ScholarsMatePgn = `[Event "Live Chess"]
+[Site "Chess.com"]
+[White "Bob"]
+[Black "Alice"]
+[Result "1-0"]
+[TimeControl "180"]
+
+1. e4 {[%clk 0:02:58]} 1... e5 {[%clk 0:02:57]} 2. Qh5 {[%clk 0:02:55]} 2... Nc6 {[%clk 0:02:50]} 3. Bc4 {[%clk 0:02:51]} 3... Nf6 {[%clk 0:02:40]} 4. Qxf7# {[%clk 0:02:49]} 1-0`
+
+	SicilianPgn = `[Event "Live Chess"]
+[Site "Chess.com"]
+[White "Alice"]
+[Black "Bob"]
+[Result "1/2-1/2"]
+[TimeControl "600+5"]
+
+1. e4 c5 2. Nf3 d6 3. d4 cxd4 4. Nxd4 Nf6 5. Nc3 a6 1/2-1/2`
+
+	// ItalianPgn has its own Termination tag, and is lost on time with little time left
+	ItalianPgn = `[Event "Live Chess"]
+[Site "Chess.com"]
+[White "Carol"]
+[Black "Bob"]
+[Result "1-0"]
+[TimeControl "180"]
+[Termination "Carol won on time"]
+
+1. e4 {[%clk 0:02:55]} 1... e5 {[%clk 0:02:00]} 2. Nf3 {[%clk 0:02:50]} 2... Nc6 {[%clk 0:00:10]} 3. Bc4 {[%clk 0:02:45]} 1-0`
+
+	Chess960Pgn = `[Event "Live Chess - Chess960"]
+[Site "Chess.com"]
+[Variant "Chess960"]
+[White "Bob"]
+[Black "Carol"]
+[Result "0-1"]
+[SetUp "1"]
+[FEN "bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9"]
+
+9. e4 Nd7 10. Nf3 e5 11. g3 0-1`
+)
+
+type Player struct {
+	Username string `json:"username"`
+	Result   string `json:"result"`
+	Rating   int    `json:"rating"`
+}
+
+// Game is a game as chess.com lists it in an archive
+type Game struct {
+	Id          string `json:"uuid"`
+	Url         string `json:"url"`
+	Pgn         string `json:"pgn"`
+	TimeControl string `json:"time_control"`
+	EndTime     int64  `json:"end_time"`
+	Rated       bool   `json:"rated"`
+	TimeClass   string `json:"time_class"`
+	Rules       string `json:"rules"`
+	White       Player `json:"white"`
+	Black       Player `json:"black"`
+}
+
+// NewGame is a rated 3 minute blitz game of standard chess that ended on 2023-11-14
+func NewGame(id string, pgn string, white Player, black Player) Game {
+	return Game{
+		Id:          id,
+		Url:         "https://www.chess.com/game/live/" + id,
+		Pgn:         pgn,
+		TimeControl: "180",
+		EndTime:     1700000000,
+		Rated:       true,
+		TimeClass:   "blitz",
+		Rules:       "chess",
+		White:       white,
+		Black:       black,
+	}
+}
+
+// Request is a request the server answered
+type Request struct {
+	Path            string
+	UserAgent       string
+	IfNoneMatch     string
+	IfModifiedSince string
+	Status          int
+}
+
+type archive struct {
+	body    []byte
+	modTime time.Time
+}
+
+// Server lists the archives of one player and serves the ones that have been set, answering conditional
+// requests for them with 304 Not Modified until they change
+type Server struct {
+	*httptest.Server
+	username string
+	months   []string
+
+	mu       sync.Mutex
+	archives map[string]archive
+	requests []Request
+}
+
+// NewServer lists the archives of username for months, such as 2023/11, which are 404 until set
+func NewServer(t testing.TB, username string, months ...string) *Server {
+	t.Helper()
+	s := &Server{username: username, months: months, archives: make(map[string]archive)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// ArchiveUrl is the url the server lists the archive of month at
+func (s *Server) ArchiveUrl(month string) string {
+	return fmt.Sprintf("%s/player/%s/games/%s", s.URL, s.username, month)
+}
+
+// SetArchive replaces the games of the archive of month
+func (s *Server) SetArchive(month string, games ...Game) {
+	if games == nil {
+		games = []Game{}
+	}
+	body, err := json.Marshal(map[string][]Game{"games": games})
+	if err != nil {
+		panic(err)
+	}
+	s.SetArchiveBody(month, string(body))
+}
+
+// SetArchiveBody replaces the archive of month with body, which doesn't need to be valid json
+func (s *Server) SetArchiveBody(month string, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// whole seconds, the resolution of Last-Modified, and later than the archive's previous version
+	modTime := time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)
+	if previous, ok := s.archives[month]; ok {
+		modTime = previous.modTime.Add(time.Second)
+	}
+	s.archives[month] = archive{[]byte(body), modTime}
+}
+
+// Requests lists the requests the server has answered, oldest first
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, Request{
+			Path:            req.URL.Path,
+			UserAgent:       req.UserAgent(),
+			IfNoneMatch:     req.Header.Get("If-None-Match"),
+			IfModifiedSince: req.Header.Get("If-Modified-Since"),
+			Status:          recorder.status,
+		})
+	}()
+
+	prefix := fmt.Sprintf("/player/%s/games/", s.username)
+	if req.URL.Path == prefix+"archives" {
+		urls := make([]string, len(s.months))
+		for i, month := range s.months {
+			urls[i] = s.ArchiveUrl(month)
+		}
+		sort.Strings(urls)
+		recorder.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(recorder).Encode(map[string][]string{"archives": urls})
+		return
+	}
+
+	s.mu.Lock()
+	archive, ok := s.archives[strings.TrimPrefix(req.URL.Path, prefix)]
+	s.mu.Unlock()
+	if !strings.HasPrefix(req.URL.Path, prefix) || !ok {
+		http.NotFound(recorder, req)
+		return
+	}
+
+	recorder.Header().Set("Content-Type", "application/json")
+	recorder.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256(archive.body)))
+	http.ServeContent(recorder, req, "", archive.modTime, bytes.NewReader(archive.body))
+}
+
+// BobsArchives are the games of bob by month. In November he beats alice at blitz and draws with her at
+// rapid, in December he loses to carol at blitz on time and at chess960.
+func BobsArchives() map[string][]Game {
+	scholarsMate := NewGame("g1", ScholarsMatePgn, Player{"Bob", "win", 1500}, Player{"Alice", "checkmated", 1450})
+	scholarsMate.EndTime = 1699600000
+
+	sicilian := NewGame("g2", SicilianPgn, Player{"Alice", "agreed", 1520}, Player{"Bob", "agreed", 1510})
+	sicilian.TimeControl = "600+5"
+	sicilian.TimeClass = "rapid"
+	sicilian.EndTime = 1700500000
+
+	italian := NewGame("g3", ItalianPgn, Player{"Carol", "win", 1600}, Player{"Bob", "timeout", 1490})
+	italian.EndTime = 1701800000
+
+	chess960 := NewGame("g4", Chess960Pgn, Player{"Bob", "resigned", 1480}, Player{"Carol", "win", 1610})
+	chess960.Rules = "chess960"
+	chess960.EndTime = 1701900000
+
+	return map[string][]Game{
+		"2023/11": {scholarsMate, sicilian},
+		"2023/12": {italian, chess960},
+	}
+}
+
+// NewBobServer serves the archives of BobsArchives
+func NewBobServer(t testing.TB) *Server {
+	t.Helper()
+	s := NewServer(t, "bob", "2023/11", "2023/12")
+	for month, games := range BobsArchives() {
+		s.SetArchive(month, games...)
+	}
+	return s
+}
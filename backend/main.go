@@ -4,11 +4,14 @@ import (
 	"backend/api"
 	"backend/model"
 	"backend/types"
+	"backend/utils"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/rs/cors"
@@ -16,7 +19,8 @@ import (
 
 func cleanup(state *types.ServerState) {
 	fmt.Println("Cleaning up...")
-	for key, db := range state.DBMap {
+	for _, key := range state.DBMap.UserIds() {
+		db := state.DBMap.Get(key)
 		fmt.Printf("Closing db: %s\n", key)
 		db.Mu.Lock()
 		defer db.Mu.Unlock()
@@ -27,10 +31,52 @@ func cleanup(state *types.ServerState) {
 	fmt.Println("Cleanup complete!")
 }
 
+// rebuild regenerates the games of one or all users from the archive cache, usage: rebuild [-user <username>]
+func rebuild(state *types.ServerState, args []string) {
+	rebuildFlags := flag.NewFlagSet("rebuild", flag.ExitOnError)
+	username := rebuildFlags.String("user", "", "username to rebuild, every user is rebuilt when empty")
+	rebuildFlags.Parse(args)
+
+	var userIds []string
+	if *username != "" {
+		userIds = append(userIds, utils.Hash(*username))
+	} else {
+		userIds = state.DBMap.UserIds()
+	}
+
+	for _, userId := range userIds {
+		start := time.Now()
+		stats, err := api.RebuildUser(userId, state)
+		if err != nil {
+			fmt.Printf("Error rebuilding user %s: %s\n", userId, err)
+			continue
+		}
+
+		fmt.Printf("Rebuilt user %s in %v:\n", userId, time.Since(start))
+		api.PrintInsertStats(stats)
+	}
+}
+
 func main() {
-	state := types.NewServerState()
+	chessComConfig := model.DefaultChessComConfig()
+	flag.StringVar(&chessComConfig.BaseUrl, "chesscom-url", chessComConfig.BaseUrl, "base url of the chess.com public api")
+	flag.DurationVar(&chessComConfig.Timeout, "chesscom-timeout", chessComConfig.Timeout, "timeout for requests to the chess.com api")
+	flag.StringVar(&chessComConfig.UserAgent, "user-agent", chessComConfig.UserAgent, "user agent sent to the chess.com api")
+	flag.IntVar(&chessComConfig.MaxAttempts, "chesscom-max-attempts", chessComConfig.MaxAttempts, "maximum attempts per chess.com api request")
+	flag.IntVar(&chessComConfig.Workers, "archive-workers", chessComConfig.Workers, "archives downloaded concurrently per setup")
+	flag.Float64Var(&chessComConfig.RequestsPerSecond, "chesscom-rps", chessComConfig.RequestsPerSecond, "requests per second allowed to the chess.com api across all setups, 0 for no limit")
+	flag.IntVar(&chessComConfig.Burst, "chesscom-burst", chessComConfig.Burst, "burst size of the chess.com api rate limit")
+	cacheDir := flag.String("cache-dir", model.DefaultArchiveCacheDir, "directory raw archives are cached in, empty to disable the cache")
+	adminToken := flag.String("admin-token", "", "bearer token required by the /admin endpoints, they are disabled when empty")
+	flag.Parse()
 
-	userIds, err := model.LoadExistingDbs(&state.DBMap)
+	if *cacheDir != "" {
+		chessComConfig.Cache = model.NewArchiveCache(*cacheDir)
+	}
+
+	state := types.NewServerState(model.NewChessComClient(chessComConfig))
+
+	dbs, err := model.LoadExistingDbs()
 	if err != nil {
 		fmt.Printf("Fatal error: %s\n", err)
 		cleanup(state)
@@ -38,17 +84,38 @@ func main() {
 	}
 
 	state.SetupStatuses.Mu.Lock()
-	for _, userId := range userIds {
+	for userId, db := range dbs {
+		state.DBMap.Set(userId, types.NewLockedDB(db))
 		(*state.SetupStatuses.Resource)[userId] = types.SetupStatusPending
 	}
 	state.SetupStatuses.Mu.Unlock()
 
+	if flag.Arg(0) == "rebuild" {
+		rebuild(state, flag.Args()[1:])
+		cleanup(state)
+		os.Exit(0)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/setup", api.MakeHandler(state, api.Setup))
 	mux.HandleFunc("/gamestats", api.MakeHandler(state, api.GetGameStats))
 	mux.HandleFunc("/winstats", api.MakeHandler(state, api.GetWinStats))
 	mux.HandleFunc("/lossstats", api.MakeHandler(state, api.GetLossStats))
 	mux.HandleFunc("/drawstats", api.MakeHandler(state, api.GetDrawStats))
+	mux.HandleFunc("/timestats", api.MakeHandler(state, api.GetTimeStats))
+	mux.HandleFunc("/positions", api.MakeHandler(state, api.GetPositions))
+	mux.HandleFunc("/explorer", api.MakeHandler(state, api.GetExplorer))
+	mux.HandleFunc("/openingstats", api.MakeHandler(state, api.GetOpeningStats))
+	mux.HandleFunc("/ratinghistory", api.MakeHandler(state, api.GetRatingHistory))
+	mux.HandleFunc("/resultsovertime", api.MakeHandler(state, api.GetResultsOverTime))
+	mux.HandleFunc("/colorstats", api.MakeHandler(state, api.GetColorStats))
+	mux.HandleFunc("/opponents", api.MakeHandler(state, api.GetOpponents))
+	mux.HandleFunc("/opponents/", api.MakeHandler(state, api.GetOpponent))
+	mux.HandleFunc("/games", api.MakeHandler(state, api.GetGames))
+	mux.HandleFunc("/games/", api.MakeHandler(state, api.GetGame))
+	mux.HandleFunc("/export/pgn", api.MakeHandler(state, api.GetPgnExport))
+	mux.HandleFunc("/export/games.csv", api.MakeHandler(state, api.GetGamesCsvExport))
+	mux.HandleFunc("/admin/rebuild", api.MakeAdminHandler(state, *adminToken, api.Rebuild))
 
 	handler := cors.Default().Handler(mux)
 
@@ -0,0 +1,833 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const StartingFen = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+type Color int
+
+const (
+	White Color = 0
+	Black Color = 1
+)
+
+const (
+	kingside  = 0
+	queenside = 1
+)
+
+const noSquare = -1
+
+// Move is a move on a Board. Castling is encoded as the king capturing its own rook, which works
+// for both standard chess and chess960.
+type Move struct {
+	From      int
+	To        int
+	Promotion byte
+	Castle    bool
+}
+
+// Board is a chess position that can resolve SAN moves, supporting arbitrary starting positions
+// and chess960 castling. Squares are indexed from a1 = 0 to h8 = 63, pieces use FEN letters.
+type Board struct {
+	squares [64]byte
+	turn    Color
+
+	// rook square each side can still castle with, indexed by color then kingside/queenside
+	castleRooks [2][2]int
+
+	// square behind a pawn that has just moved two squares
+	epSquare int
+
+	halfmove int
+	fullmove int
+	chess960 bool
+}
+
+func NewBoard() *Board {
+	b, _ := ParseFen(StartingFen)
+	return b
+}
+
+func squareOf(file int, rank int) int {
+	return rank*8 + file
+}
+
+func fileOf(sq int) int {
+	return sq % 8
+}
+
+func rankOf(sq int) int {
+	return sq / 8
+}
+
+func squareName(sq int) string {
+	return string([]byte{byte('a' + fileOf(sq)), byte('1' + rankOf(sq))})
+}
+
+func parseSquare(s string) (sq int, err error) {
+	if len(s) != 2 || s[0] < 'a' || s[0] > 'h' || s[1] < '1' || s[1] > '8' {
+		return noSquare, fmt.Errorf("invalid square: %s", s)
+	}
+	return squareOf(int(s[0]-'a'), int(s[1]-'1')), nil
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func sign(x int) int {
+	if x < 0 {
+		return -1
+	} else if x > 0 {
+		return 1
+	}
+	return 0
+}
+
+func pieceColor(piece byte) Color {
+	if piece >= 'a' {
+		return Black
+	}
+	return White
+}
+
+func pieceType(piece byte) byte {
+	if piece >= 'a' {
+		return piece - 'a' + 'A'
+	}
+	return piece
+}
+
+func colorPiece(pieceType byte, color Color) byte {
+	if color == Black {
+		return pieceType - 'A' + 'a'
+	}
+	return pieceType
+}
+
+func (c Color) backRank() int {
+	if c == Black {
+		return 7
+	}
+	return 0
+}
+
+func (c Color) forward() int {
+	if c == Black {
+		return -1
+	}
+	return 1
+}
+
+// ParseFen parses a FEN, accepting KQkq, Shredder-FEN and X-FEN castling rights
+func ParseFen(fen string) (b *Board, err error) {
+	fields := strings.Fields(fen)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("invalid fen, expected at least 4 fields: %s", fen)
+	}
+
+	b = &Board{
+		castleRooks: [2][2]int{{noSquare, noSquare}, {noSquare, noSquare}},
+		epSquare:    noSquare,
+		fullmove:    1,
+	}
+
+	ranks := strings.Split(fields[0], "/")
+	if len(ranks) != 8 {
+		return nil, fmt.Errorf("invalid fen placement: %s", fields[0])
+	}
+	for i, rankStr := range ranks {
+		rank := 7 - i
+		file := 0
+		for _, c := range rankStr {
+			switch {
+			case c >= '1' && c <= '8':
+				file += int(c - '0')
+			case strings.ContainsRune("PNBRQKpnbrqk", c):
+				if file > 7 {
+					return nil, fmt.Errorf("invalid fen placement: %s", fields[0])
+				}
+				b.squares[squareOf(file, rank)] = byte(c)
+				file++
+			default:
+				return nil, fmt.Errorf("invalid fen placement: %s", fields[0])
+			}
+		}
+		if file != 8 {
+			return nil, fmt.Errorf("invalid fen placement: %s", fields[0])
+		}
+	}
+
+	switch fields[1] {
+	case "w":
+		b.turn = White
+	case "b":
+		b.turn = Black
+	default:
+		return nil, fmt.Errorf("invalid fen side to move: %s", fields[1])
+	}
+
+	if fields[2] != "-" {
+		for _, c := range fields[2] {
+			if err := b.parseCastleRight(byte(c)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if fields[3] != "-" {
+		if b.epSquare, err = parseSquare(fields[3]); err != nil {
+			return nil, fmt.Errorf("invalid fen en passant square: %w", err)
+		}
+	}
+
+	if len(fields) >= 6 {
+		if b.halfmove, err = strconv.Atoi(fields[4]); err != nil {
+			return nil, fmt.Errorf("invalid fen halfmove clock: %w", err)
+		}
+		if b.fullmove, err = strconv.Atoi(fields[5]); err != nil {
+			return nil, fmt.Errorf("invalid fen fullmove number: %w", err)
+		}
+	}
+
+	// castling with anything but the corner rooks of a king on the e file only happens in chess960
+	for color := White; color <= Black; color++ {
+		for side := kingside; side <= queenside; side++ {
+			rook := b.castleRooks[color][side]
+			if rook == noSquare {
+				continue
+			}
+			if fileOf(b.kingSquare(color)) != 4 || (fileOf(rook) != 0 && fileOf(rook) != 7) {
+				b.chess960 = true
+			}
+		}
+	}
+
+	return b, nil
+}
+
+func (b *Board) parseCastleRight(c byte) error {
+	color := White
+	if c >= 'a' {
+		color = Black
+	}
+	rank := color.backRank()
+
+	king := b.kingSquare(color)
+	if king == noSquare || rankOf(king) != rank {
+		return fmt.Errorf("invalid fen castling rights %c: king not on back rank", c)
+	}
+	rook := colorPiece('R', color)
+
+	upper := pieceType(c)
+	switch {
+	case upper == 'K' || upper == 'Q':
+		// X-FEN, the outermost rook on that side of the king
+		side, start, step := kingside, 7, -1
+		if upper == 'Q' {
+			side, start, step = queenside, 0, 1
+		}
+		for file := start; file != fileOf(king); file += step {
+			if b.squares[squareOf(file, rank)] == rook {
+				b.castleRooks[color][side] = squareOf(file, rank)
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid fen castling rights %c: no rook", c)
+	case upper >= 'A' && upper <= 'H':
+		// Shredder-FEN, the file of the rook
+		sq := squareOf(int(upper-'A'), rank)
+		if b.squares[sq] != rook {
+			return fmt.Errorf("invalid fen castling rights %c: no rook", c)
+		}
+		side := kingside
+		if fileOf(sq) < fileOf(king) {
+			side = queenside
+		}
+		b.castleRooks[color][side] = sq
+		return nil
+	}
+
+	return fmt.Errorf("invalid fen castling rights: %c", c)
+}
+
+func (b *Board) kingSquare(color Color) int {
+	king := colorPiece('K', color)
+	for sq, piece := range b.squares {
+		if piece == king {
+			return sq
+		}
+	}
+	return noSquare
+}
+
+func (c Color) String() string {
+	if c == Black {
+		return "black"
+	}
+	return "white"
+}
+
+func (b *Board) Turn() Color {
+	return b.turn
+}
+
+func (b *Board) Chess960() bool {
+	return b.chess960
+}
+
+func (b *Board) placementFen() string {
+	var sb strings.Builder
+	for rank := 7; rank >= 0; rank-- {
+		empty := 0
+		for file := 0; file < 8; file++ {
+			piece := b.squares[squareOf(file, rank)]
+			if piece == 0 {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				sb.WriteByte(byte('0' + empty))
+				empty = 0
+			}
+			sb.WriteByte(piece)
+		}
+		if empty > 0 {
+			sb.WriteByte(byte('0' + empty))
+		}
+		if rank > 0 {
+			sb.WriteByte('/')
+		}
+	}
+	return sb.String()
+}
+
+// castlingFen writes X-FEN castling rights, which is plain KQkq for standard chess
+func (b *Board) castlingFen() string {
+	var sb strings.Builder
+	for color := White; color <= Black; color++ {
+		for side := kingside; side <= queenside; side++ {
+			rook := b.castleRooks[color][side]
+			if rook == noSquare {
+				continue
+			}
+
+			right := byte('K')
+			if side == queenside {
+				right = 'Q'
+			}
+			if !b.isOutermostRook(color, side, rook) {
+				right = byte('A' + fileOf(rook))
+			}
+			sb.WriteByte(colorPiece(right, color))
+		}
+	}
+
+	if sb.Len() == 0 {
+		return "-"
+	}
+	return sb.String()
+}
+
+func (b *Board) isOutermostRook(color Color, side int, rook int) bool {
+	step := 1
+	if side == queenside {
+		step = -1
+	}
+	for file := fileOf(rook) + step; file >= 0 && file < 8; file += step {
+		if b.squares[squareOf(file, rankOf(rook))] == colorPiece('R', color) {
+			return false
+		}
+	}
+	return true
+}
+
+// epFen only reports the en passant square when a pawn is actually in position to capture on it,
+// so transpositions reached with and without a double pawn push compare equal
+func (b *Board) epFen() string {
+	if !b.epCapturable() {
+		return "-"
+	}
+	return squareName(b.epSquare)
+}
+
+func (b *Board) epCapturable() bool {
+	if b.epSquare == noSquare {
+		return false
+	}
+
+	pawn := colorPiece('P', b.turn)
+	captureRank := rankOf(b.epSquare) - b.turn.forward()
+	for _, df := range []int{-1, 1} {
+		file := fileOf(b.epSquare) + df
+		if file >= 0 && file < 8 && b.squares[squareOf(file, captureRank)] == pawn {
+			return true
+		}
+	}
+	return false
+}
+
+// PositionFen is the FEN without the move counters, which is what positions are stored and compared by
+func (b *Board) PositionFen() string {
+	turn := "w"
+	if b.turn == Black {
+		turn = "b"
+	}
+	return strings.Join([]string{b.placementFen(), turn, b.castlingFen(), b.epFen()}, " ")
+}
+
+func (b *Board) Fen() string {
+	return fmt.Sprintf("%s %d %d", b.PositionFen(), b.halfmove, b.fullmove)
+}
+
+var pieceValues = map[byte]int{'P': 1, 'N': 3, 'B': 3, 'R': 5, 'Q': 9}
+
+// Material is the total value of the pieces of color, counting pawns as 1
+func (b *Board) Material(color Color) (material int) {
+	for _, piece := range b.squares {
+		if piece != 0 && pieceColor(piece) == color {
+			material += pieceValues[pieceType(piece)]
+		}
+	}
+	return
+}
+
+func (b *Board) NonPawnMaterial(color Color) int {
+	pawns := 0
+	for _, piece := range b.squares {
+		if piece == colorPiece('P', color) {
+			pawns++
+		}
+	}
+	return b.Material(color) - pawns
+}
+
+func (b *Board) pathClear(from int, to int) bool {
+	df := sign(fileOf(to) - fileOf(from))
+	dr := sign(rankOf(to) - rankOf(from))
+	step := dr*8 + df
+	for sq := from + step; sq != to; sq += step {
+		if b.squares[sq] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// attacks reports whether the piece on from attacks to, ignoring pins
+func (b *Board) attacks(from int, to int) bool {
+	piece := b.squares[from]
+	df := fileOf(to) - fileOf(from)
+	dr := rankOf(to) - rankOf(from)
+	if df == 0 && dr == 0 {
+		return false
+	}
+
+	switch pieceType(piece) {
+	case 'P':
+		return abs(df) == 1 && dr == pieceColor(piece).forward()
+	case 'N':
+		return (abs(df) == 1 && abs(dr) == 2) || (abs(df) == 2 && abs(dr) == 1)
+	case 'K':
+		return abs(df) <= 1 && abs(dr) <= 1
+	case 'B':
+		return abs(df) == abs(dr) && b.pathClear(from, to)
+	case 'R':
+		return (df == 0 || dr == 0) && b.pathClear(from, to)
+	case 'Q':
+		return (abs(df) == abs(dr) || df == 0 || dr == 0) && b.pathClear(from, to)
+	}
+	return false
+}
+
+func (b *Board) isAttacked(sq int, by Color) bool {
+	for from, piece := range b.squares {
+		if piece != 0 && pieceColor(piece) == by && b.attacks(from, sq) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Board) InCheck() bool {
+	king := b.kingSquare(b.turn)
+	return king != noSquare && b.isAttacked(king, 1-b.turn)
+}
+
+func castleTargets(color Color, side int) (kingTo int, rookTo int) {
+	rank := color.backRank()
+	if side == kingside {
+		return squareOf(6, rank), squareOf(5, rank)
+	}
+	return squareOf(2, rank), squareOf(3, rank)
+}
+
+func (b *Board) castleMove(side int) (m Move, err error) {
+	king := b.kingSquare(b.turn)
+	rook := b.castleRooks[b.turn][side]
+	if king == noSquare || rook == noSquare {
+		return m, errors.New("castling not allowed")
+	}
+
+	kingTo, rookTo := castleTargets(b.turn, side)
+	lo, hi := king, king
+	for _, sq := range []int{rook, kingTo, rookTo} {
+		if sq < lo {
+			lo = sq
+		}
+		if sq > hi {
+			hi = sq
+		}
+	}
+	for sq := lo; sq <= hi; sq++ {
+		if sq != king && sq != rook && b.squares[sq] != 0 {
+			return m, errors.New("castling path blocked")
+		}
+	}
+
+	step := sign(kingTo - king)
+	for sq := king; ; sq += step {
+		if b.isAttacked(sq, 1-b.turn) {
+			return m, errors.New("castling through check")
+		}
+		if sq == kingTo {
+			break
+		}
+	}
+
+	// in chess960 the castling rook can be what shields the king's destination
+	m = Move{From: king, To: rook, Castle: true}
+	if !b.isLegal(m) {
+		return m, errors.New("castling into check")
+	}
+	return m, nil
+}
+
+func (b *Board) isLegal(m Move) bool {
+	after := *b
+	after.MakeMove(m)
+	king := after.kingSquare(b.turn)
+	return king != noSquare && !after.isAttacked(king, after.turn)
+}
+
+func (b *Board) canPawnMove(from int, to int) bool {
+	forward := b.turn.forward()
+	df := fileOf(to) - fileOf(from)
+	dr := rankOf(to) - rankOf(from)
+	target := b.squares[to]
+
+	if df == 0 {
+		if target != 0 {
+			return false
+		}
+		if dr == forward {
+			return true
+		}
+		startRank := 1
+		if b.turn == Black {
+			startRank = 6
+		}
+		return dr == 2*forward && rankOf(from) == startRank && b.squares[from+8*forward] == 0
+	}
+
+	if abs(df) != 1 || dr != forward {
+		return false
+	}
+	if target != 0 {
+		return pieceColor(target) != b.turn
+	}
+	return to == b.epSquare
+}
+
+// legalMoves generates every legal move in the position, promotions once per promotion piece
+func (b *Board) legalMoves() (moves []Move) {
+	for from, piece := range b.squares {
+		if piece == 0 || pieceColor(piece) != b.turn {
+			continue
+		}
+		isPawn := pieceType(piece) == 'P'
+
+		for to, target := range b.squares {
+			if target != 0 && pieceColor(target) == b.turn {
+				continue
+			}
+			if isPawn && !b.canPawnMove(from, to) || !isPawn && !b.attacks(from, to) {
+				continue
+			}
+
+			promotions := []byte{0}
+			if isPawn && (rankOf(to) == 0 || rankOf(to) == 7) {
+				promotions = []byte{'N', 'B', 'R', 'Q'}
+			}
+			for _, promotion := range promotions {
+				if m := (Move{From: from, To: to, Promotion: promotion}); b.isLegal(m) {
+					moves = append(moves, m)
+				}
+			}
+		}
+	}
+
+	for side := kingside; side <= queenside; side++ {
+		if m, err := b.castleMove(side); err == nil {
+			moves = append(moves, m)
+		}
+	}
+	return
+}
+
+// ParseSan resolves a move in standard algebraic notation against the position
+func (b *Board) ParseSan(san string) (m Move, err error) {
+	move := strings.TrimRight(san, "+#!?")
+	switch move {
+	case "O-O", "0-0":
+		return b.castleMove(kingside)
+	case "O-O-O", "0-0-0":
+		return b.castleMove(queenside)
+	}
+
+	var promotion byte
+	if i := strings.IndexByte(move, '='); i >= 0 {
+		if i+2 != len(move) {
+			return m, fmt.Errorf("invalid promotion in %s", san)
+		}
+		promotion = move[i+1]
+		move = move[:i]
+	} else if n := len(move); n > 2 && strings.IndexByte("NBRQ", move[n-1]) >= 0 && move[0] >= 'a' && move[0] <= 'h' {
+		promotion = move[n-1]
+		move = move[:n-1]
+	}
+	if promotion != 0 && strings.IndexByte("NBRQ", promotion) < 0 {
+		return m, fmt.Errorf("invalid promotion in %s", san)
+	}
+
+	if len(move) < 2 {
+		return m, fmt.Errorf("invalid move: %s", san)
+	}
+
+	movingType := byte('P')
+	if strings.IndexByte("NBRQK", move[0]) >= 0 {
+		movingType = move[0]
+		move = move[1:]
+	}
+	if movingType != 'P' && promotion != 0 {
+		return m, fmt.Errorf("invalid promotion in %s", san)
+	}
+
+	if len(move) < 2 {
+		return m, fmt.Errorf("invalid move: %s", san)
+	}
+	to, err := parseSquare(move[len(move)-2:])
+	if err != nil {
+		return m, fmt.Errorf("invalid move %s: %w", san, err)
+	}
+	disambiguation := strings.ReplaceAll(move[:len(move)-2], "x", "")
+
+	fromFile, fromRank := -1, -1
+	for _, c := range disambiguation {
+		switch {
+		case c >= 'a' && c <= 'h':
+			fromFile = int(c - 'a')
+		case c >= '1' && c <= '8':
+			fromRank = int(c - '1')
+		default:
+			return m, fmt.Errorf("invalid move: %s", san)
+		}
+	}
+
+	if target := b.squares[to]; target != 0 && pieceColor(target) == b.turn {
+		return m, fmt.Errorf("illegal move %s: destination occupied", san)
+	}
+
+	piece := colorPiece(movingType, b.turn)
+	var candidates []Move
+	for from, p := range b.squares {
+		if p != piece || (fromFile >= 0 && fileOf(from) != fromFile) || (fromRank >= 0 && rankOf(from) != fromRank) {
+			continue
+		}
+
+		if movingType == 'P' {
+			if !b.canPawnMove(from, to) {
+				continue
+			}
+		} else if !b.attacks(from, to) {
+			continue
+		}
+
+		candidate := Move{From: from, To: to, Promotion: promotion}
+		if b.isLegal(candidate) {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return m, fmt.Errorf("illegal move: %s", san)
+	}
+	if len(candidates) > 1 {
+		return m, fmt.Errorf("ambiguous move: %s", san)
+	}
+
+	m = candidates[0]
+	promotes := movingType == 'P' && (rankOf(to) == 0 || rankOf(to) == 7)
+	if promotes && m.Promotion == 0 {
+		return m, fmt.Errorf("missing promotion in %s", san)
+	}
+	if !promotes && m.Promotion != 0 {
+		return m, fmt.Errorf("invalid promotion in %s", san)
+	}
+	return m, nil
+}
+
+// MakeMove plays m, which must be legal in the position
+func (b *Board) MakeMove(m Move) {
+	color := b.turn
+	piece := b.squares[m.From]
+	captured := b.squares[m.To]
+
+	b.halfmove++
+	if pieceType(piece) == 'P' || (captured != 0 && !m.Castle) {
+		b.halfmove = 0
+	}
+
+	epSquare := noSquare
+	if m.Castle {
+		side := kingside
+		if fileOf(m.To) < fileOf(m.From) {
+			side = queenside
+		}
+		kingTo, rookTo := castleTargets(color, side)
+		b.squares[m.From] = 0
+		b.squares[m.To] = 0
+		b.squares[kingTo] = colorPiece('K', color)
+		b.squares[rookTo] = colorPiece('R', color)
+	} else {
+		if pieceType(piece) == 'P' {
+			if m.To == b.epSquare && captured == 0 && fileOf(m.To) != fileOf(m.From) {
+				b.squares[m.To-8*color.forward()] = 0
+			}
+			if abs(m.To-m.From) == 16 {
+				epSquare = m.From + 8*color.forward()
+			}
+		}
+
+		b.squares[m.To] = piece
+		b.squares[m.From] = 0
+		if m.Promotion != 0 {
+			b.squares[m.To] = colorPiece(m.Promotion, color)
+		}
+	}
+
+	if pieceType(piece) == 'K' {
+		b.castleRooks[color] = [2]int{noSquare, noSquare}
+	}
+	for c := White; c <= Black; c++ {
+		for side := kingside; side <= queenside; side++ {
+			if rook := b.castleRooks[c][side]; rook == m.From || rook == m.To {
+				b.castleRooks[c][side] = noSquare
+			}
+		}
+	}
+
+	b.epSquare = epSquare
+	if color == Black {
+		b.fullmove++
+	}
+	b.turn = 1 - color
+}
+
+// San writes the move in standard algebraic notation, which must be legal in the position
+func (b *Board) San(m Move) string {
+	piece := b.squares[m.From]
+	var sb strings.Builder
+
+	switch {
+	case m.Castle:
+		sb.WriteString("O-O")
+		if fileOf(m.To) < fileOf(m.From) {
+			sb.WriteString("-O")
+		}
+	case pieceType(piece) == 'P':
+		if fileOf(m.To) != fileOf(m.From) {
+			sb.WriteByte(byte('a' + fileOf(m.From)))
+			sb.WriteByte('x')
+		}
+		sb.WriteString(squareName(m.To))
+		if m.Promotion != 0 {
+			sb.WriteByte('=')
+			sb.WriteByte(m.Promotion)
+		}
+	default:
+		sb.WriteByte(pieceType(piece))
+		sb.WriteString(b.disambiguation(m))
+		if b.squares[m.To] != 0 {
+			sb.WriteByte('x')
+		}
+		sb.WriteString(squareName(m.To))
+	}
+
+	after := *b
+	after.MakeMove(m)
+	if after.InCheck() {
+		if len(after.legalMoves()) == 0 {
+			sb.WriteByte('#')
+		} else {
+			sb.WriteByte('+')
+		}
+	}
+	return sb.String()
+}
+
+// disambiguation is the file, rank or square of m.From needed to tell m apart from the other legal moves
+// of the same kind of piece to the same square
+func (b *Board) disambiguation(m Move) string {
+	ambiguous, sameFile, sameRank := false, false, false
+	for from, piece := range b.squares {
+		if from == m.From || piece != b.squares[m.From] {
+			continue
+		}
+		other := Move{From: from, To: m.To}
+		if !b.attacks(from, m.To) || !b.isLegal(other) {
+			continue
+		}
+		ambiguous = true
+		sameFile = sameFile || fileOf(from) == fileOf(m.From)
+		sameRank = sameRank || rankOf(from) == rankOf(m.From)
+	}
+
+	switch {
+	case !ambiguous:
+		return ""
+	case !sameFile:
+		return squareName(m.From)[:1]
+	case !sameRank:
+		return squareName(m.From)[1:]
+	}
+	return squareName(m.From)
+}
+
+// Uci writes the move in UCI notation, castling is written king to rook in chess960 and king to
+// its destination otherwise
+func (b *Board) Uci(m Move) string {
+	to := m.To
+	if m.Castle && !b.chess960 {
+		side := kingside
+		if fileOf(m.To) < fileOf(m.From) {
+			side = queenside
+		}
+		to, _ = castleTargets(pieceColor(b.squares[m.From]), side)
+	}
+
+	uci := squareName(m.From) + squareName(to)
+	if m.Promotion != 0 {
+		uci += string(m.Promotion - 'A' + 'a')
+	}
+	return uci
+}
@@ -0,0 +1,272 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+// perft counts the leaf nodes of the legal move tree depth plies deep
+func perft(b *Board, depth int) int {
+	if depth == 0 {
+		return 1
+	}
+	moves := b.legalMoves()
+	if depth == 1 {
+		return len(moves)
+	}
+
+	nodes := 0
+	for _, m := range moves {
+		after := *b
+		after.MakeMove(m)
+		nodes += perft(&after, depth-1)
+	}
+	return nodes
+}
+
+func TestPerft(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		nodes []int
+	}{
+		{"start", StartingFen, []int{20, 400, 8902, 197281}},
+		{"kiwipete", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", []int{48, 2039, 97862}},
+		{"en passant pins", "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1", []int{14, 191, 2812, 43238}},
+		{"promotions", "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1", []int{6, 264, 9467}},
+		{"discovered checks", "rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8", []int{44, 1486, 62379}},
+		{"chess960 bqnb1rkr", "bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", []int{21, 528, 12189, 326672}},
+		{"chess960 2nnrbkr", "2nnrbkr/p1qppppp/8/1ppb4/6PP/3PP3/PPP2P2/BQNNRBKR w HEhe - 1 9", []int{21, 807, 18002}},
+		{"chess960 b1q1rrkb", "b1q1rrkb/pppppppp/3nn3/8/P7/1PPP4/4PPPP/BQNNRKRB w GE - 1 9", []int{20, 479, 10471}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := ParseFen(tt.fen)
+			if err != nil {
+				t.Fatalf("ParseFen: %s", err)
+			}
+			for i, expected := range tt.nodes {
+				if nodes := perft(b, i+1); nodes != expected {
+					t.Errorf("perft(%d) = %d, expected %d", i+1, nodes, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestSanRoundTrip(t *testing.T) {
+	for _, fen := range []string{
+		StartingFen,
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		"r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1",
+		"4k3/8/8/8/Q6Q/8/8/Q3K3 w - - 0 1",
+		"4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1",
+		"bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9",
+		"4k3/8/8/8/8/8/8/1R3KR1 w GB - 0 1",
+	} {
+		b, err := ParseFen(fen)
+		if err != nil {
+			t.Fatalf("ParseFen(%q): %s", fen, err)
+		}
+		for _, m := range b.legalMoves() {
+			san := b.San(m)
+			if parsed, err := b.ParseSan(san); err != nil || parsed != m {
+				t.Errorf("%s in %s parsed back as %s, %v", san, fen, b.Uci(parsed), err)
+			}
+		}
+	}
+}
+
+func TestSan(t *testing.T) {
+	tests := []struct {
+		fen string
+		uci string
+		san string
+	}{
+		{StartingFen, "g1f3", "Nf3"},
+		{"4k3/8/8/8/8/8/8/2N1K1N1 w - - 0 1", "c1e2", "Nce2"},
+		{"4k3/8/8/R7/8/8/8/R3K3 w - - 0 1", "a1a3", "R1a3"},
+		{"4k3/8/8/8/Q6Q/8/8/Q3K3 w - - 0 1", "a4d4", "Qa4d4"},
+		{"4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "e5d6", "exd6"},
+		{"1r2k3/P7/8/8/8/8/8/4K3 w - - 0 1", "a7b8q", "axb8=Q+"},
+		{"r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1c1", "O-O-O"},
+		{"r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq - 4 4", "h5f7", "Qxf7#"},
+	}
+
+	for _, tt := range tests {
+		b, err := ParseFen(tt.fen)
+		if err != nil {
+			t.Fatalf("ParseFen(%q): %s", tt.fen, err)
+		}
+		found := false
+		for _, m := range b.legalMoves() {
+			if b.Uci(m) == tt.uci {
+				found = true
+				if san := b.San(m); san != tt.san {
+					t.Errorf("San(%s) in %s = %s, expected %s", tt.uci, tt.fen, san, tt.san)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s isn't legal in %s", tt.uci, tt.fen)
+		}
+	}
+}
+
+// playSan plays moves from fen, failing the test on the first one that doesn't parse
+func playSan(t *testing.T, fen string, moves string) *Board {
+	t.Helper()
+	b, err := ParseFen(fen)
+	if err != nil {
+		t.Fatalf("ParseFen(%q): %s", fen, err)
+	}
+	for _, san := range strings.Fields(moves) {
+		m, err := b.ParseSan(san)
+		if err != nil {
+			t.Fatalf("ParseSan(%q) in %s: %s", san, b.Fen(), err)
+		}
+		b.MakeMove(m)
+	}
+	return b
+}
+
+func TestParseSan(t *testing.T) {
+	tests := []struct {
+		name string
+		fen  string
+		san  string
+		uci  string
+		err  string
+	}{
+		{"pawn push", StartingFen, "e4", "e2e4", ""},
+		{"knight", StartingFen, "Nf3", "g1f3", ""},
+		{"annotations", StartingFen, "Nf3!?", "g1f3", ""},
+		{"blocked double push", "4k3/8/8/8/8/4n3/4P3/4K3 w - - 0 1", "e4", "", "illegal move"},
+
+		// disambiguation
+		{"ambiguous knights", "4k3/8/8/8/8/8/8/2N1K1N1 w - - 0 1", "Ne2", "", "ambiguous move"},
+		{"knight by file", "4k3/8/8/8/8/8/8/2N1K1N1 w - - 0 1", "Nce2", "c1e2", ""},
+		{"rook by rank", "4k3/8/8/R7/8/8/8/R3K3 w - - 0 1", "R1a3", "a1a3", ""},
+		{"three queens", "4k3/8/8/8/Q6Q/8/8/Q3K3 w - - 0 1", "Qd4", "", "ambiguous move"},
+		{"three queens by file", "4k3/8/8/8/Q6Q/8/8/Q3K3 w - - 0 1", "Qad4", "", "ambiguous move"},
+		{"three queens by rank", "4k3/8/8/8/Q6Q/8/8/Q3K3 w - - 0 1", "Q4d4", "", "ambiguous move"},
+		{"three queens by square", "4k3/8/8/8/Q6Q/8/8/Q3K3 w - - 0 1", "Qa1d4", "a1d4", ""},
+
+		// pins
+		{"pinned knight", "4k3/4r3/8/8/8/8/4N3/4K1N1 w - - 0 1", "Ne2f4", "", "illegal move"},
+		{"pin resolves ambiguity", "4k3/4r3/8/8/8/8/4N3/4K1N1 w - - 0 1", "Nf4", "", "illegal move"},
+		{"pin leaves one knight", "4k3/4r3/8/8/8/8/2N1N3/4K3 w - - 0 1", "Nd4", "c2d4", ""},
+		{"pinned pawn", "4k3/8/8/b7/8/8/3P4/4K3 w - - 0 1", "d3", "", "illegal move"},
+		{"king into check", "4k3/8/8/8/8/8/3r4/4K3 w - - 0 1", "Kf2", "", "illegal move"},
+
+		// en passant
+		{"en passant", "4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1", "exd6", "e5d6", ""},
+		{"en passant missed", "4k3/8/8/3pP3/8/8/8/4K3 w - - 0 1", "exd6", "", "illegal move"},
+		{"en passant pinned on rank", "8/8/8/K2pP2r/8/8/8/4k3 w - d6 0 1", "exd6", "", "illegal move"},
+
+		// promotion
+		{"promotion", "4k3/P7/8/8/8/8/8/4K3 w - - 0 1", "a8=Q", "a7a8q", ""},
+		{"promotion without equals", "4k3/P7/8/8/8/8/8/4K3 w - - 0 1", "a8N", "a7a8n", ""},
+		{"promotion capture", "1r2k3/P7/8/8/8/8/8/4K3 w - - 0 1", "axb8=R+", "a7b8r", ""},
+		{"underpromotion capture", "r3k3/1P6/8/8/8/8/8/4K3 w - - 0 1", "bxa8=B", "b7a8b", ""},
+		{"missing promotion", "4k3/P7/8/8/8/8/8/4K3 w - - 0 1", "a8", "", "missing promotion"},
+		{"promotion to king", "4k3/P7/8/8/8/8/8/4K3 w - - 0 1", "a8=K", "", "invalid promotion"},
+		{"black promotion", "4k3/8/8/8/8/8/p7/4K3 b - - 0 1", "a1=Q+", "a2a1q", ""},
+		{"pawn promoting short of the last rank", StartingFen, "e4=Q", "", "invalid promotion"},
+		{"pawn promoting short of the last rank without equals", StartingFen, "e4Q", "", "invalid promotion"},
+		{"knight promoting", StartingFen, "Nf3=Q", "", "invalid promotion"},
+		{"king promoting on the last rank", "8/4k3/8/8/8/8/8/4K3 b - - 0 1", "Ke8=Q", "", "invalid promotion"},
+
+		// castling
+		{"castle kingside", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "O-O", "e1g1", ""},
+		{"castle queenside", "r3k2r/8/8/8/8/8/8/R3K2R b KQkq - 0 1", "O-O-O", "e8c8", ""},
+		{"castle out of check", "r3k2r/8/8/8/8/8/4r3/R3K2R w KQkq - 0 1", "O-O", "", "castling through check"},
+		{"castle through check", "r3k2r/8/8/8/8/8/5r2/R3K2R w KQkq - 0 1", "O-O", "", "castling through check"},
+		{"castle into check", "r3k2r/8/8/8/8/8/6r1/R3K2R w KQkq - 0 1", "O-O", "", "castling through check"},
+		{"queenside rook attacked", "r3k2r/8/8/8/8/8/1r6/R3K2R w KQkq - 0 1", "O-O-O", "e1c1", ""},
+		{"castle blocked", "r3k2r/8/8/8/8/8/8/R3KB1R w KQkq - 0 1", "O-O", "", "castling path blocked"},
+		{"castle without rights", "r3k2r/8/8/8/8/8/8/R3K2R w Qkq - 0 1", "O-O", "", "castling not allowed"},
+		{"chess960 castle", "4k3/8/8/8/8/8/8/1R3KR1 w GB - 0 1", "O-O", "f1g1", ""},
+		{"chess960 castle queenside", "4k3/8/8/8/8/8/8/1R3KR1 w GB - 0 1", "O-O-O", "f1b1", ""},
+		{"chess960 castle onto a rook", "bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", "O-O", "", "castling path blocked"},
+		{"chess960 rook shields the king", "4k3/8/8/8/8/8/8/rR2K3 w B - 0 1", "O-O-O", "", "castling into check"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := ParseFen(tt.fen)
+			if err != nil {
+				t.Fatalf("ParseFen: %s", err)
+			}
+
+			m, err := b.ParseSan(tt.san)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("ParseSan(%q) returned %v, expected an error containing %q", tt.san, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSan(%q): %s", tt.san, err)
+			}
+			if uci := b.Uci(m); uci != tt.uci {
+				t.Errorf("ParseSan(%q) = %s, expected %s", tt.san, uci, tt.uci)
+			}
+		})
+	}
+}
+
+func TestPositionFen(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		moves string
+		after string
+	}{
+		{"start", StartingFen, "", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -"},
+		{"no ep without a capturing pawn", StartingFen, "e4", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq -"},
+		{"ep with a capturing pawn", StartingFen, "e4 Nf6 e5 d5", "rnbqkb1r/ppp1pppp/5n2/3pP3/8/8/PPPP1PPP/RNBQKBNR w KQkq d6"},
+		{"ep gone after a move", StartingFen, "e4 Nf6 e5 d5 Nf3", "rnbqkb1r/ppp1pppp/5n2/3pP3/8/5N2/PPPP1PPP/RNBQKB1R b KQkq -"},
+		{"ep capture", StartingFen, "e4 Nf6 e5 d5 exd6", "rnbqkb1r/ppp1pppp/3P1n2/8/8/8/PPPP1PPP/RNBQKBNR b KQkq -"},
+		{"unreachable ep dropped", "4k3/8/8/8/3p4/8/8/4K3 w - e3 0 1", "", "4k3/8/8/8/3p4/8/8/4K3 w - -"},
+		{"king move drops rights", StartingFen, "e4 e5 Ke2", "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPPKPPP/RNBQ1BNR b kq -"},
+		{"rook move drops one right", StartingFen, "h4 a5 Rh3 Ra6", "1nbqkbnr/1ppppppp/r7/p7/7P/7R/PPPPPPP1/RNBQKBN1 w Qk -"},
+		{"rook capture drops the right", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "Rxa8+", "R3k2r/8/8/8/8/8/8/4K2R b Kk -"},
+		{"castling", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "O-O O-O-O", "2kr3r/8/8/8/8/8/8/R4RK1 w - -"},
+		{"promotion capture", "1r2k3/P7/8/8/8/8/8/4K3 w - - 0 1", "axb8=Q+", "1Q2k3/8/8/8/8/8/8/4K3 b - -"},
+
+		// castling rights
+		{"shredder fen", "r3k2r/8/8/8/8/8/8/R3K2R w HAha - 0 1", "", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq -"},
+		{"chess960 x-fen", "bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", "", "bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w KQkq -"},
+		{"chess960 inner rook", "rk2r3/8/8/8/8/8/8/RK2R2R w EAea - 0 1", "", "rk2r3/8/8/8/8/8/8/RK2R2R w EQkq -"},
+		{"chess960 castling", "4k3/8/8/8/8/8/8/1R3KR1 w GB - 0 1", "O-O", "4k3/8/8/8/8/8/8/1R3RK1 b - -"},
+		{"chess960 castling queenside", "4k3/8/8/8/8/8/8/1R3KR1 w GB - 0 1", "O-O-O", "4k3/8/8/8/8/8/8/2KR2R1 b - -"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := playSan(t, tt.fen, tt.moves)
+			if fen := b.PositionFen(); fen != tt.after {
+				t.Errorf("position fen %s, expected %s", fen, tt.after)
+			}
+		})
+	}
+}
+
+func TestParseFenErrors(t *testing.T) {
+	for _, fen := range []string{
+		"",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP w KQkq -",
+		"rnbqkbnr/pppppppp/9/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR x KQkq -",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkqX -",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBN1 w K -",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq e9",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - x 1",
+	} {
+		if _, err := ParseFen(fen); err == nil {
+			t.Errorf("ParseFen(%q) succeeded", fen)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package model
+
+import (
+	"backend/utils"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const DefaultArchiveCacheDir = "archive-cache"
+
+// ArchiveCache keeps the gzipped raw json of every downloaded archive along with the
+// validators needed to make conditional requests for it later
+type ArchiveCache struct {
+	dir string
+}
+
+type ArchiveCacheMeta struct {
+	Url          string `json:"url"`
+	ETag         string `json:"etag"`
+	LastModified string `json:"lastModified"`
+}
+
+// archiveCacheWriter compresses an archive body into a temp file that only replaces the
+// cached copy once the body has been read and decoded successfully
+type archiveCacheWriter struct {
+	cache *ArchiveCache
+	meta  ArchiveCacheMeta
+	file  *os.File
+	gz    *gzip.Writer
+}
+
+func NewArchiveCache(dir string) *ArchiveCache {
+	return &ArchiveCache{dir: dir}
+}
+
+func (ac *ArchiveCache) key(url string) string {
+	return utils.Hash(url)
+}
+
+func (ac *ArchiveCache) dataPath(url string) string {
+	return filepath.Join(ac.dir, ac.key(url)+".json.gz")
+}
+
+func (ac *ArchiveCache) metaPath(url string) string {
+	return filepath.Join(ac.dir, ac.key(url)+".meta.json")
+}
+
+func (ac *ArchiveCache) Meta(url string) (meta ArchiveCacheMeta, exists bool, err error) {
+	if _, err = os.Stat(ac.dataPath(url)); errors.Is(err, os.ErrNotExist) {
+		return meta, false, nil
+	} else if err != nil {
+		return
+	}
+
+	data, err := ioutil.ReadFile(ac.metaPath(url))
+	if errors.Is(err, os.ErrNotExist) {
+		return meta, false, nil
+	} else if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(data, &meta); err != nil {
+		err = fmt.Errorf("error parsing archive cache meta: %w", err)
+		return
+	}
+
+	return meta, true, nil
+}
+
+// Open returns the decompressed raw json of a cached archive
+func (ac *ArchiveCache) Open(url string) (io.ReadCloser, error) {
+	file, err := os.Open(ac.dataPath(url))
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading cached archive %s: %w", url, err)
+	}
+
+	return &gzipFileReader{Reader: gz, file: file}, nil
+}
+
+// Archives lists the urls of every cached archive belonging to username
+func (ac *ArchiveCache) Archives(username string) (archives []string, err error) {
+	metaPaths, err := filepath.Glob(filepath.Join(ac.dir, "*.meta.json"))
+	if err != nil {
+		return
+	}
+
+	playerPath := fmt.Sprintf("/player/%s/", strings.ToLower(username))
+	for _, metaPath := range metaPaths {
+		data, err := ioutil.ReadFile(metaPath)
+		if err != nil {
+			return nil, err
+		}
+
+		var meta ArchiveCacheMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("error parsing archive cache meta %s: %w", metaPath, err)
+		}
+
+		if strings.Contains(strings.ToLower(meta.Url), playerPath) {
+			archives = append(archives, meta.Url)
+		}
+	}
+
+	return
+}
+
+// GetAllGames parses cached archives the same way ChessComClient.GetAllGames parses downloaded ones,
+// without touching the network
+func (ac *ArchiveCache) GetAllGames(ctx context.Context, archives []string, games chan<- Game) GamesResult {
+	return streamGames(ctx, archives, runtime.NumCPU(), ac.readArchive, games)
+}
+
+func (ac *ArchiveCache) readArchive(ctx context.Context, url string, rawGames chan<- RawGame) (numGames int, err error) {
+	body, err := ac.Open(url)
+	if err != nil {
+		err = fmt.Errorf("error opening cached archive: %w", err)
+		return
+	}
+	defer body.Close()
+
+	if err = decodeArchive(body, sendRawGames(ctx, rawGames, &numGames)); err != nil {
+		err = fmt.Errorf("error parsing cached archive json: %w", err)
+	}
+	return
+}
+
+func (ac *ArchiveCache) newWriter(meta ArchiveCacheMeta) (*archiveCacheWriter, error) {
+	if err := os.MkdirAll(ac.dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating archive cache dir: %w", err)
+	}
+
+	file, err := ioutil.TempFile(ac.dir, ac.key(meta.Url)+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("error creating archive cache file: %w", err)
+	}
+
+	return &archiveCacheWriter{
+		cache: ac,
+		meta:  meta,
+		file:  file,
+		gz:    gzip.NewWriter(file),
+	}, nil
+}
+
+func (w *archiveCacheWriter) Write(p []byte) (int, error) {
+	return w.gz.Write(p)
+}
+
+func (w *archiveCacheWriter) commit() (err error) {
+	defer w.abort()
+
+	if err = w.gz.Close(); err != nil {
+		return
+	}
+	if err = w.file.Close(); err != nil {
+		return
+	}
+	if err = os.Rename(w.file.Name(), w.cache.dataPath(w.meta.Url)); err != nil {
+		return
+	}
+
+	data, err := json.Marshal(w.meta)
+	if err != nil {
+		return
+	}
+
+	metaFile, err := ioutil.TempFile(w.cache.dir, w.cache.key(w.meta.Url)+".*.tmp")
+	if err != nil {
+		return
+	}
+	if _, err = metaFile.Write(data); err != nil {
+		metaFile.Close()
+		os.Remove(metaFile.Name())
+		return
+	}
+	if err = metaFile.Close(); err != nil {
+		os.Remove(metaFile.Name())
+		return
+	}
+
+	return os.Rename(metaFile.Name(), w.cache.metaPath(w.meta.Url))
+}
+
+// abort discards the temp file, it is a no-op after a successful commit
+func (w *archiveCacheWriter) abort() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+type gzipFileReader struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r *gzipFileReader) Close() error {
+	r.Reader.Close()
+	return r.file.Close()
+}
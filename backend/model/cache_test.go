@@ -0,0 +1,121 @@
+package model
+
+import (
+	"backend/chesscomtest"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+type getAllGames func(ctx context.Context, archives []string, games chan<- Game) GamesResult
+
+// getGames returns the ids of the games of archives, from the chess.com client or cache getAllGames belongs to
+func getGames(t *testing.T, getAllGames getAllGames, archives []string) (ids map[string]bool, result GamesResult) {
+	t.Helper()
+	games := make(chan Game)
+	resultCh := make(chan GamesResult, 1)
+	go func() {
+		resultCh <- getAllGames(context.Background(), archives, games)
+	}()
+
+	ids = make(map[string]bool)
+	for game := range games {
+		ids[game.Id] = true
+	}
+	return ids, <-resultCh
+}
+
+// archiveRequests are the requests for archives the server answered since the first skipped requests
+func archiveRequests(server *chesscomtest.Server, skip int) (requests map[string]chesscomtest.Request) {
+	requests = make(map[string]chesscomtest.Request)
+	for _, req := range server.Requests()[skip:] {
+		requests[server.URL+req.Path] = req
+	}
+	return
+}
+
+func TestArchiveCacheConditionalRequests(t *testing.T) {
+	server := chesscomtest.NewBobServer(t)
+	cacheDir := t.TempDir()
+	cache := NewArchiveCache(cacheDir)
+	client := newTestClient(server.URL, cache)
+
+	archives, err := client.ListArchives("bob")
+	if err != nil {
+		t.Fatalf("ListArchives: %s", err)
+	}
+
+	ids, result := getGames(t, client.GetAllGames, archives)
+	if len(ids) != 4 || len(result.Failed()) != 0 {
+		t.Fatalf("first download got games %v and failures %v, expected 4 games", ids, result.Failed())
+	}
+	metas := make(map[string]ArchiveCacheMeta)
+	for url, req := range archiveRequests(server, 1) {
+		if req.IfNoneMatch != "" || req.IfModifiedSince != "" || req.Status != http.StatusOK {
+			t.Errorf("first request for %s is %+v, expected an unconditional 200", url, req)
+		}
+		meta, exists, err := cache.Meta(url)
+		if err != nil || !exists || meta.ETag == "" || meta.LastModified == "" {
+			t.Errorf("cache meta of %s is %+v, %v, %v, expected its validators", url, meta, exists, err)
+		}
+		metas[url] = meta
+	}
+
+	// unchanged archives are revalidated and read from the cache
+	numRequests := len(server.Requests())
+	ids, result = getGames(t, client.GetAllGames, archives)
+	if len(ids) != 4 || len(result.Failed()) != 0 || result.NumGames() != 4 {
+		t.Errorf("second download got games %v and result %+v, expected the 4 games from the cache", ids, result)
+	}
+	requests := archiveRequests(server, numRequests)
+	if len(requests) != 2 {
+		t.Fatalf("second download sent %v, expected a request per archive", requests)
+	}
+	for url, req := range requests {
+		meta := metas[url]
+		if req.IfNoneMatch != meta.ETag || req.IfModifiedSince != meta.LastModified || req.Status != http.StatusNotModified {
+			t.Errorf("second request for %s is %+v, expected the validators %+v answered with 304", url, req, meta)
+		}
+	}
+
+	// a changed archive replaces its cached copy
+	december := chesscomtest.BobsArchives()["2023/12"]
+	server.SetArchive("2023/12", december[0])
+	numRequests = len(server.Requests())
+	ids, _ = getGames(t, client.GetAllGames, archives)
+	if len(ids) != 3 || ids["g4"] {
+		t.Errorf("download after December changed got games %v, expected g1 to g3", ids)
+	}
+	if req := archiveRequests(server, numRequests)[archives[1]]; req.Status != http.StatusOK {
+		t.Errorf("request for the changed archive is %+v, expected a 200", req)
+	}
+	if meta, _, _ := cache.Meta(archives[1]); meta.ETag == metas[archives[1]].ETag {
+		t.Errorf("cache kept the ETag %s of the old archive", meta.ETag)
+	}
+	ids, _ = getGames(t, cache.GetAllGames, archives)
+	if len(ids) != 3 {
+		t.Errorf("cached archives hold games %v, expected g1 to g3", ids)
+	}
+
+	// an archive whose body breaks off keeps the cached copy and leaves no temp file behind
+	server.SetArchiveBody("2023/11", `{"games": [{"uuid": "g1", "pgn": "1. e4`)
+	_, result = getGames(t, client.GetAllGames, archives[:1])
+	if len(result.Failed()) != 1 {
+		t.Errorf("truncated archive gave %+v, expected it to fail", result)
+	}
+	if meta, _, _ := cache.Meta(archives[0]); meta != metas[archives[0]] {
+		t.Errorf("cache meta of the truncated archive is %+v, expected the previous %+v", meta, metas[archives[0]])
+	}
+	if ids, _ = getGames(t, cache.GetAllGames, archives[:1]); len(ids) != 2 || !ids["g1"] || !ids["g2"] {
+		t.Errorf("cache holds games %v of the truncated archive, expected g1 and g2", ids)
+	}
+	if tmpFiles, _ := filepath.Glob(filepath.Join(cacheDir, "*.tmp")); len(tmpFiles) != 0 {
+		t.Errorf("temp files %v left in the cache", tmpFiles)
+	}
+	files, _ := ioutil.ReadDir(cacheDir)
+	if len(files) != 4 {
+		t.Errorf("%d files in the cache, expected the data and meta of 2 archives", len(files))
+	}
+}
@@ -1,16 +1,70 @@
 package model
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"runtime"
 	"strings"
 	"sync"
+	"time"
+)
+
+const (
+	DefaultChessComBaseUrl   = "https://api.chess.com/pub"
+	DefaultChessComTimeout   = 30 * time.Second
+	DefaultChessComUserAgent = "chess-com-dashboard/1.0"
+	DefaultArchiveWorkers    = 4
+	DefaultRequestsPerSecond = 3
+	DefaultRequestBurst      = 3
+
+	rawGameBufferSize = 256
 
-	"gopkg.in/freeeve/pgn.v1"
+	VariantStandard = "standard"
+	VariantChess960 = "chess960"
 )
 
+type ChessComConfig struct {
+	BaseUrl   string
+	Timeout   time.Duration
+	UserAgent string
+
+	// total attempts per request, including the first one
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+
+	// number of archives downloaded concurrently by a single GetAllGames call
+	Workers int
+
+	// limits requests across every caller of the client, a rate of 0 disables the limit
+	RequestsPerSecond float64
+	Burst             int
+
+	// optional, a client built from Timeout is used when nil
+	HttpClient *http.Client
+
+	// optional, archives are always downloaded in full when nil
+	Cache *ArchiveCache
+}
+
+type ChessComClient struct {
+	baseUrl    string
+	userAgent  string
+	httpClient *http.Client
+
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+
+	workers int
+	limiter *RateLimiter
+	cache   *ArchiveCache
+}
+
 type ArchivesData struct {
 	Archives []string `json:"archives"`
 }
@@ -23,40 +77,165 @@ type GamePlayer struct {
 	Rating   uint16 `json:"rating"`
 }
 
+type Accuracies struct {
+	White float64 `json:"white"`
+	Black float64 `json:"black"`
+}
+
 type RawGame struct {
-	Id          string     `json:"uuid"`
-	Url         string     `json:"url"`
-	Pgn         string     `json:"pgn"`
-	TimeControl string     `json:"time_control"`
-	EndTime     uint32     `json:"end_time"`
-	IsRated     bool       `json:"rated"`
-	TimeClass   string     `json:"time_class"`
-	WhitePlayer GamePlayer `json:"white"`
-	BlackPlayer GamePlayer `json:"black"`
+	Id          string      `json:"uuid"`
+	Url         string      `json:"url"`
+	Pgn         string      `json:"pgn"`
+	TimeControl string      `json:"time_control"`
+	EndTime     uint32      `json:"end_time"`
+	IsRated     bool        `json:"rated"`
+	TimeClass   string      `json:"time_class"`
+	WhitePlayer GamePlayer  `json:"white"`
+	BlackPlayer GamePlayer  `json:"black"`
+	Accuracies  *Accuracies `json:"accuracies"`
+	Tcn         string      `json:"tcn"`
+	Rules       string      `json:"rules"`
+	EcoUrl      string      `json:"eco"`
+
+	// only set for daily games
+	StartTime uint32 `json:"start_time"`
+}
+
+type Position struct {
+	// 0 is the starting position
+	Ply        int
+	Fen        string
+	SideToMove string
+
+	// Zobrist hash of Fen, which is its id in the fens table
+	FenId int64
+
+	// move played from this position, empty for the final position
+	San string
+	Uci string
+
+	// move that reached this position, empty for the starting position
+	PrevSan string
+	PrevUci string
+
+	// clock of the player who made PrevSan after making it, and how long they thought about it.
+	// nil when the pgn has no clock, TimeSpent is also nil for daily games.
+	Clock     *time.Duration
+	TimeSpent *time.Duration
+
+	Phase string
 }
 
 type Game struct {
 	RawGame
-	Fens []string
+	Variant   string
+	Positions []Position
+
+	// deepest named opening the game passed through, nil for variants and unrecognised openings
+	Opening *Opening
 }
 
 type Archive struct {
 	Games []RawGame `json:"games"`
 }
 
-func ListArchives(user string) (archives []string, err error) {
-	fmt.Println("Requesting list of archives...")
-	url := fmt.Sprintf("http://api.chess.com/pub/player/%s/games/archives", user)
-	resp, err := http.Get(url)
+type ArchiveResult struct {
+	Url      string
+	NumGames int
+	Err      error
+}
+
+type GamesResult struct {
+	// one entry per requested archive, in request order
+	Archives []ArchiveResult
+}
+
+func (r GamesResult) NumGames() (numGames int) {
+	for _, archive := range r.Archives {
+		numGames += archive.NumGames
+	}
+	return
+}
+
+func (r GamesResult) Failed() (failed []ArchiveResult) {
+	for _, archive := range r.Archives {
+		if archive.Err != nil {
+			failed = append(failed, archive)
+		}
+	}
+	return
+}
+
+func DefaultChessComConfig() ChessComConfig {
+	return ChessComConfig{
+		BaseUrl:     DefaultChessComBaseUrl,
+		Timeout:     DefaultChessComTimeout,
+		UserAgent:   DefaultChessComUserAgent,
+		MaxAttempts: DefaultMaxAttempts,
+		MinBackoff:  DefaultMinBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+
+		Workers:           DefaultArchiveWorkers,
+		RequestsPerSecond: DefaultRequestsPerSecond,
+		Burst:             DefaultRequestBurst,
+	}
+}
+
+func NewChessComClient(config ChessComConfig) *ChessComClient {
+	httpClient := config.HttpClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: config.Timeout}
+	}
+
+	maxAttempts := config.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	maxBackoff := config.MaxBackoff
+	if maxBackoff < config.MinBackoff {
+		maxBackoff = config.MinBackoff
+	}
+
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &ChessComClient{
+		baseUrl:     strings.TrimSuffix(config.BaseUrl, "/"),
+		userAgent:   config.UserAgent,
+		httpClient:  httpClient,
+		maxAttempts: maxAttempts,
+		minBackoff:  config.MinBackoff,
+		maxBackoff:  maxBackoff,
+		workers:     workers,
+		limiter:     NewRateLimiter(config.RequestsPerSecond, config.Burst),
+		cache:       config.Cache,
+	}
+}
+
+func (c *ChessComClient) get(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		err = fmt.Errorf("error requesting archives: %w", err)
-		return
+		return nil, err
 	}
-	defer resp.Body.Close()
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	return c.httpClient.Do(req)
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+func (c *ChessComClient) ListArchives(user string) (archives []string, err error) {
+	fmt.Println("Requesting list of archives...")
+	url := fmt.Sprintf("%s/player/%s/games/archives", c.baseUrl, user)
+	body, err := c.fetch(context.Background(), url, nil)
 	if err != nil {
-		err = fmt.Errorf("error parsing archives list body: %w", err)
+		err = fmt.Errorf("error requesting archives: %w", err)
 		return
 	}
 
@@ -70,57 +249,208 @@ func ListArchives(user string) (archives []string, err error) {
 	return
 }
 
-func getArchive(url string, wg *sync.WaitGroup, ch chan<- []Game) {
-	defer wg.Done()
+// decodeArchive streams the games of an archive body one at a time instead of unmarshalling the whole archive
+func decodeArchive(r io.Reader, onGame func(RawGame) error) (err error) {
+	dec := json.NewDecoder(r)
+	if err = expectDelim(dec, '{'); err != nil {
+		return
+	}
+
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		if key, _ := token.(string); key != "games" {
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
+		for dec.More() {
+			var game RawGame
+			if err := dec.Decode(&game); err != nil {
+				return err
+			}
+			if err := onGame(game); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %s but found %v", delim, token)
+	}
+	return nil
+}
+
+func (c *ChessComClient) Cache() *ArchiveCache {
+	return c.cache
+}
 
-	resp, err := http.Get(url)
+// cachedArchiveHeader returns the conditional request headers for url if it has been cached before
+func (c *ChessComClient) cachedArchiveHeader(url string) http.Header {
+	if c.cache == nil {
+		return nil
+	}
+
+	meta, exists, err := c.cache.Meta(url)
+	if err != nil {
+		fmt.Printf("Error reading archive cache for %s: %s\n", url, err)
+		return nil
+	}
+	if !exists {
+		return nil
+	}
+
+	header := http.Header{}
+	if meta.ETag != "" {
+		header.Set("If-None-Match", meta.ETag)
+	}
+	if meta.LastModified != "" {
+		header.Set("If-Modified-Since", meta.LastModified)
+	}
+	return header
+}
+
+// getArchive sends the games of an archive to rawGames, reading unchanged archives from the cache
+// and caching the body of any archive that has to be downloaded
+func (c *ChessComClient) getArchive(ctx context.Context, url string, rawGames chan<- RawGame) (numGames int, err error) {
+	resp, err := c.open(ctx, url, c.cachedArchiveHeader(url))
 	if err != nil {
-		fmt.Println("Error requesting archive")
+		err = fmt.Errorf("error requesting archive: %w", err)
 		return
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	var body io.Reader = resp.Body
+	var cacheWriter *archiveCacheWriter
+	if resp.StatusCode == http.StatusNotModified {
+		cachedBody, err := c.cache.Open(url)
+		if err != nil {
+			return 0, fmt.Errorf("error opening cached archive: %w", err)
+		}
+		defer cachedBody.Close()
+		body = cachedBody
+	} else if c.cache != nil {
+		cacheWriter, err = c.cache.newWriter(ArchiveCacheMeta{
+			Url:          url,
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+		})
+		if err != nil {
+			fmt.Printf("Error caching archive %s: %s\n", url, err)
+			err = nil
+		} else {
+			defer cacheWriter.abort()
+			body = io.TeeReader(resp.Body, cacheWriter)
+		}
+	}
+
+	err = decodeArchive(body, sendRawGames(ctx, rawGames, &numGames))
 	if err != nil {
-		fmt.Println("Error parsing archive body")
+		err = fmt.Errorf("error parsing archive json: %w", err)
 		return
 	}
 
-	var data Archive
-	if err := json.Unmarshal(body, &data); err != nil {
-		fmt.Println("Error parsing archive json")
-		return
+	if cacheWriter != nil {
+		// the decoder stops at the closing brace, copy whatever follows so the cached body is complete
+		if _, err := io.Copy(ioutil.Discard, body); err != nil {
+			fmt.Printf("Error caching archive %s: %s\n", url, err)
+		} else if err := cacheWriter.commit(); err != nil {
+			fmt.Printf("Error caching archive %s: %s\n", url, err)
+		}
 	}
 
-	var games []Game
-	for _, game := range data.Games {
-		games = append(games, parseGame(&game))
+	return
+}
+
+func gameVariant(rules string) string {
+	if rules == "" || rules == "chess" {
+		return VariantStandard
 	}
+	return rules
+}
 
-	ch <- games
+func startingBoard(tags map[string]string, variant string) (b *Board, err error) {
+	fen, hasFen := tags["FEN"]
+	if !hasFen {
+		return NewBoard(), nil
+	}
+
+	if b, err = ParseFen(fen); err != nil {
+		return
+	}
+	if variant == VariantChess960 {
+		b.chess960 = true
+	}
+	return
+}
+
+func newPosition(b *Board, ply int) Position {
+	return Position{
+		Ply:        ply,
+		Fen:        b.PositionFen(),
+		FenId:      b.Hash(),
+		SideToMove: b.Turn().String(),
+		Phase:      gamePhase(b, ply),
+	}
 }
 
 func parseGame(rawGame *RawGame) Game {
-	var fens []string
-
-	// variants tend to break pgn parser
-	if !strings.Contains(rawGame.Pgn, "[Variant \"") {
-		ps := pgn.NewPGNScanner(strings.NewReader(rawGame.Pgn))
-		for ps.Next() {
-			game, err := ps.Scan()
-			if err != nil {
-				continue
-			}
+	var positions []Position
+	variant := gameVariant(rawGame.Rules)
 
-			b := pgn.NewBoard()
-			for _, move := range game.Moves {
-				if err := b.MakeMove(move); err != nil {
-					continue
+	base, increment, hasBase := ParseTimeControl(rawGame.TimeControl)
+	if pgnGame, err := ParsePgn(rawGame.Pgn); err == nil {
+		if b, err := startingBoard(pgnGame.Tags, variant); err == nil {
+			previousClocks := [2]time.Duration{base, base}
+			positions = append(positions, newPosition(b, 0))
+			for i, move := range pgnGame.Moves {
+				// moves that don't exist in standard chess, like crazyhouse drops, end the positions early
+				m, err := b.ParseSan(move.San)
+				if err != nil {
+					break
 				}
-				fenParts := strings.Split(b.String(), " ")
-				fenStr := strings.Join(fenParts[:len(fenParts)-2], " ")
+				mover := b.Turn()
+				uci := b.Uci(m)
+				b.MakeMove(m)
+
+				positions[i].San = move.San
+				positions[i].Uci = uci
 
-				fens = append(fens, fenStr)
+				position := newPosition(b, i+1)
+				position.PrevSan = move.San
+				position.PrevUci = uci
+				if clock, ok := parseClock(move.Comment); ok {
+					position.Clock = &clock
+					if hasBase {
+						timeSpent := previousClocks[mover] + increment - clock
+						if timeSpent < 0 {
+							timeSpent = 0
+						}
+						position.TimeSpent = &timeSpent
+					}
+					previousClocks[mover] = clock
+				}
+				positions = append(positions, position)
 			}
 		}
 	}
@@ -128,29 +458,100 @@ func parseGame(rawGame *RawGame) Game {
 	rawGame.WhitePlayer.Username = strings.ToLower(rawGame.WhitePlayer.Username)
 	rawGame.BlackPlayer.Username = strings.ToLower(rawGame.BlackPlayer.Username)
 
-	return Game{
-		RawGame: *rawGame,
-		Fens:    fens,
+	game := Game{
+		RawGame:   *rawGame,
+		Variant:   variant,
+		Positions: positions,
+	}
+	if variant == VariantStandard {
+		fenIds := make([]int64, len(positions))
+		for i, position := range positions {
+			fenIds[i] = position.FenId
+		}
+		if opening, ok := ClassifyOpening(fenIds); ok {
+			game.Opening = &opening
+		}
 	}
-}
 
-func GetAllGames(archives []string) []Game {
-	var wg sync.WaitGroup
+	return game
+}
 
+// GetAllGames downloads, decodes and parses archives concurrently, sending each parsed game on games as soon
+// as it is ready. Every stage hands work to the next over a bounded channel so a slow consumer of games
+// slows the downloads down instead of buffering them. games is closed once every archive has been handled.
+func (c *ChessComClient) GetAllGames(ctx context.Context, archives []string, games chan<- Game) GamesResult {
 	fmt.Println("Requesting games...")
-	gamesCh := make(chan []Game, len(archives))
-	for _, archive := range archives {
-		wg.Add(1)
-		go getArchive(archive, &wg, gamesCh)
+	return streamGames(ctx, archives, c.workers, c.getArchive, games)
+}
+
+type archiveReader func(ctx context.Context, url string, rawGames chan<- RawGame) (numGames int, err error)
+
+func streamGames(
+	ctx context.Context,
+	archives []string,
+	workers int,
+	readArchive archiveReader,
+	games chan<- Game,
+) GamesResult {
+	defer close(games)
+
+	results := make([]ArchiveResult, len(archives))
+	rawGames := make(chan RawGame, rawGameBufferSize)
+	indexCh := make(chan int)
+
+	var readWg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		readWg.Add(1)
+		go func() {
+			defer readWg.Done()
+			for i := range indexCh {
+				numGames, err := readArchive(ctx, archives[i], rawGames)
+				if err != nil {
+					fmt.Printf("Error getting archive %s: %s\n", archives[i], err)
+				}
+				results[i] = ArchiveResult{
+					Url:      archives[i],
+					NumGames: numGames,
+					Err:      err,
+				}
+			}
+		}()
 	}
 
-	wg.Wait()
-	close(gamesCh)
+	var parseWg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		parseWg.Add(1)
+		go func() {
+			defer parseWg.Done()
+			for rawGame := range rawGames {
+				select {
+				case games <- parseGame(&rawGame):
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
 
-	var allGames []Game
-	for games := range gamesCh {
-		allGames = append(allGames, games...)
+	for i := range archives {
+		indexCh <- i
 	}
+	close(indexCh)
+	readWg.Wait()
+	close(rawGames)
+	parseWg.Wait()
 
-	return allGames
+	return GamesResult{Archives: results}
+}
+
+// sendRawGames returns a decodeArchive callback that forwards games to rawGames, counting them in numGames
+func sendRawGames(ctx context.Context, rawGames chan<- RawGame, numGames *int) func(RawGame) error {
+	return func(game RawGame) error {
+		select {
+		case rawGames <- game:
+			*numGames++
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 }
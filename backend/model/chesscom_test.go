@@ -0,0 +1,142 @@
+package model
+
+import (
+	"backend/chesscomtest"
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openTestDb creates a migrated user db in a temporary directory
+func openTestDb(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := OpenUserDb(filepath.Join(t.TempDir(), "user"))
+	if err != nil {
+		t.Fatalf("error opening db: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestClient makes single attempts at baseUrl without rate limiting, caching archives in cache if it's
+// not nil
+func newTestClient(baseUrl string, cache *ArchiveCache) *ChessComClient {
+	config := DefaultChessComConfig()
+	config.BaseUrl = baseUrl
+	config.UserAgent = "test-agent"
+	config.MaxAttempts = 1
+	config.RequestsPerSecond = 0
+	config.Cache = cache
+	return NewChessComClient(config)
+}
+
+// ingest downloads archives with client and inserts their games into db as bob's
+func ingest(t *testing.T, client *ChessComClient, db *sql.DB, archives []string) (InsertStatistics, GamesResult) {
+	t.Helper()
+	games := make(chan Game)
+	resultCh := make(chan GamesResult, 1)
+	go func() {
+		resultCh <- client.GetAllGames(context.Background(), archives, games)
+	}()
+
+	stats, err := InsertUserData(db, "user", "bob", games, archives)
+	if err != nil {
+		t.Fatalf("InsertUserData: %s", err)
+	}
+	return stats, <-resultCh
+}
+
+func TestChessComClientIngest(t *testing.T) {
+	server := chesscomtest.NewBobServer(t)
+	client := newTestClient(server.URL, nil)
+
+	archives, err := client.ListArchives("bob")
+	if err != nil {
+		t.Fatalf("ListArchives: %s", err)
+	}
+	if len(archives) != 2 {
+		t.Fatalf("ListArchives returned %d archives, expected 2", len(archives))
+	}
+
+	db := openTestDb(t)
+	stats, result := ingest(t, client, db, archives)
+	if failed := result.Failed(); len(failed) != 0 {
+		t.Errorf("archives failed: %v", failed)
+	}
+	if result.NumGames() != 4 || stats.NumGamesInserted != 4 || stats.NumGameInsertErrors != 0 || stats.NumPositionInsertErrors != 0 {
+		t.Errorf("got %d games downloaded and stats %+v, expected 4 games inserted without errors", result.NumGames(), stats)
+	}
+
+	for _, req := range server.Requests() {
+		if req.UserAgent != "test-agent" {
+			t.Errorf("request for %s sent with user agent %q", req.Path, req.UserAgent)
+		}
+	}
+
+	gameTests := []struct {
+		id           string
+		white        string
+		winner       sql.NullString
+		result       string
+		variant      string
+		eco          sql.NullString
+		numPositions int
+	}{
+		{"g1", "bob", sql.NullString{String: "bob", Valid: true}, "checkmated", VariantStandard, sql.NullString{String: "C20", Valid: true}, 8},
+		{"g2", "alice", sql.NullString{}, "agreed", VariantStandard, sql.NullString{String: "B90", Valid: true}, 11},
+		{"g3", "carol", sql.NullString{String: "carol", Valid: true}, "timeout", VariantStandard, sql.NullString{String: "C50", Valid: true}, 6},
+		{"g4", "bob", sql.NullString{String: "carol", Valid: true}, "resigned", VariantChess960, sql.NullString{}, 6},
+	}
+	for _, tt := range gameTests {
+		var white, result, variant string
+		var winner, eco sql.NullString
+		var numPositions int
+		err := db.QueryRow(`
+		SELECT g.white_player, g.winner, g.result, g.variant, g.eco, COUNT(p.ply)
+		FROM games g
+		JOIN game_positions p ON p.game_id = g.id
+		WHERE g.id = ?
+		GROUP BY g.id
+		`, tt.id).Scan(&white, &winner, &result, &variant, &eco, &numPositions)
+		if err != nil {
+			t.Errorf("game %s: %s", tt.id, err)
+			continue
+		}
+
+		got := fmt.Sprint(white, winner, result, variant, eco, numPositions)
+		expected := fmt.Sprint(tt.white, tt.winner, tt.result, tt.variant, tt.eco, tt.numPositions)
+		if got != expected {
+			t.Errorf("game %s stored as %s, expected %s", tt.id, got, expected)
+		}
+	}
+
+	latestArchive, err := GetMostRecentArchive("user", db)
+	if err != nil || latestArchive != archives[1] {
+		t.Errorf("GetMostRecentArchive returned %q, %v, expected %q", latestArchive, err, archives[1])
+	}
+}
+
+func TestChessComClientArchiveFailure(t *testing.T) {
+	server := chesscomtest.NewServer(t, "bob", "2023/11", "2023/12")
+	server.SetArchive("2023/11", chesscomtest.BobsArchives()["2023/11"][0])
+	client := newTestClient(server.URL, nil)
+
+	archives, err := client.ListArchives("bob")
+	if err != nil {
+		t.Fatalf("ListArchives: %s", err)
+	}
+
+	db := openTestDb(t)
+	stats, result := ingest(t, client, db, archives)
+	failed := result.Failed()
+	if len(failed) != 1 || failed[0].Url != archives[1] {
+		t.Errorf("failed archives %v, expected only %s", failed, archives[1])
+	}
+	if stats.NumGamesInserted != 1 {
+		t.Errorf("%d games inserted, expected 1", stats.NumGamesInserted)
+	}
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PhaseOpening    = "opening"
+	PhaseMiddlegame = "middlegame"
+	PhaseEndgame    = "endgame"
+
+	// the first 10 moves of each side
+	openingPlies = 20
+
+	// non-pawn material of both sides combined, the starting position has 62
+	endgameMaterial = 26
+)
+
+var clockRegex = regexp.MustCompile(`\[%clk (\d+):(\d+):(\d+(?:\.\d+)?)\]`)
+
+// parseClock reads the remaining time chess.com writes into the comment after each move
+func parseClock(comment string) (clock time.Duration, ok bool) {
+	match := clockRegex.FindStringSubmatch(comment)
+	if match == nil {
+		return
+	}
+
+	hours, _ := strconv.Atoi(match[1])
+	minutes, _ := strconv.Atoi(match[2])
+	seconds, err := strconv.ParseFloat(match[3], 64)
+	if err != nil {
+		return
+	}
+
+	clock = time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+	return clock, true
+}
+
+// ParseTimeControl parses live time controls such as "180" and "180+2". Daily time controls such as
+// "1/86400" have no starting clock and are reported as not ok.
+func ParseTimeControl(timeControl string) (base time.Duration, increment time.Duration, ok bool) {
+	baseStr, incrementStr, hasIncrement := strings.Cut(timeControl, "+")
+	baseSeconds, err := strconv.Atoi(baseStr)
+	if err != nil {
+		return
+	}
+
+	incrementSeconds := 0
+	if hasIncrement {
+		if incrementSeconds, err = strconv.Atoi(incrementStr); err != nil {
+			return
+		}
+	}
+
+	return time.Duration(baseSeconds) * time.Second, time.Duration(incrementSeconds) * time.Second, true
+}
+
+func gamePhase(b *Board, ply int) string {
+	if ply <= openingPlies {
+		return PhaseOpening
+	}
+	if b.NonPawnMaterial(White)+b.NonPawnMaterial(Black) <= endgameMaterial {
+		return PhaseEndgame
+	}
+	return PhaseMiddlegame
+}
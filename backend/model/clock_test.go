@@ -0,0 +1,84 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeControl(t *testing.T) {
+	tests := []struct {
+		timeControl string
+		base        time.Duration
+		increment   time.Duration
+		ok          bool
+	}{
+		{"180", 3 * time.Minute, 0, true},
+		{"180+2", 3 * time.Minute, 2 * time.Second, true},
+		{"600+5", 10 * time.Minute, 5 * time.Second, true},
+		{"1/86400", 0, 0, false},
+		{"1/259200", 0, 0, false},
+		{"", 0, 0, false},
+		{"180+", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		base, increment, ok := ParseTimeControl(tt.timeControl)
+		if base != tt.base || increment != tt.increment || ok != tt.ok {
+			t.Errorf("ParseTimeControl(%q) = %v, %v, %v, expected %v, %v, %v",
+				tt.timeControl, base, increment, ok, tt.base, tt.increment, tt.ok)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		comment string
+		clock   time.Duration
+		ok      bool
+	}{
+		{"[%clk 0:02:58]", 2*time.Minute + 58*time.Second, true},
+		{"[%clk 0:00:09.7]", 9700 * time.Millisecond, true},
+		{"[%clk 1:30:00]", 90 * time.Minute, true},
+		{"[%clk 71:59:59]", 72*time.Hour - time.Second, true},
+		{"good move [%clk 0:01:00] [%emt 0:00:02]", time.Minute, true},
+		{"", 0, false},
+		{"[%emt 0:00:02]", 0, false},
+		{"[%clk 2:58]", 0, false},
+	}
+
+	for _, tt := range tests {
+		clock, ok := parseClock(tt.comment)
+		if clock != tt.clock || ok != tt.ok {
+			t.Errorf("parseClock(%q) = %v, %v, expected %v, %v", tt.comment, clock, ok, tt.clock, tt.ok)
+		}
+	}
+}
+
+func TestGamePhase(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		ply   int
+		phase string
+	}{
+		{"start", StartingFen, 0, PhaseOpening},
+		{"last opening ply", StartingFen, openingPlies, PhaseOpening},
+		{"full material after the opening", StartingFen, openingPlies + 1, PhaseMiddlegame},
+		{"early queen trade stays opening", "rnb1kbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNB1KBNR w KQkq - 0 4", 6, PhaseOpening},
+		{"queens and a rook each", "3qk2r/pppp1ppp/8/8/8/8/PPPP1PPP/3QK2R w - - 0 30", 58, PhaseMiddlegame},
+		{"at the endgame threshold", "3qk1n1/pppp1ppp/8/8/8/8/PPPP1PPP/3QK2R w - - 0 30", 58, PhaseEndgame},
+		{"rook and two minors each", "2b1k2r/pppp1ppp/5n2/8/8/5N2/PPPP1PPP/2B1K2R w - - 0 30", 58, PhaseEndgame},
+		{"king and pawns", "4k3/pppp1ppp/8/8/8/8/PPPP1PPP/4K3 w - - 0 40", 78, PhaseEndgame},
+		{"bare kings in the opening", "4k3/8/8/8/8/8/8/4K3 w - - 0 5", 8, PhaseOpening},
+	}
+
+	for _, tt := range tests {
+		b, err := ParseFen(tt.fen)
+		if err != nil {
+			t.Fatalf("ParseFen(%q): %s", tt.fen, err)
+		}
+		if phase := gamePhase(b, tt.ply); phase != tt.phase {
+			t.Errorf("%s: gamePhase = %s, expected %s", tt.name, phase, tt.phase)
+		}
+	}
+}
@@ -1,70 +1,59 @@
 package model
 
 import (
-	"backend/types"
-	"crypto/sha256"
 	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const insertBatchSize = 5000
 
 type InsertStatistics struct {
-	NumGamesInserted        int
-	NumPositionsInserted    int
-	NumGameInsertErrors     int
-	NumPositionInsertErrors int
+	NumGamesInserted        int `json:"gamesInserted"`
+	NumPositionsInserted    int `json:"positionsInserted"`
+	NumGameInsertErrors     int `json:"gameInsertErrors"`
+	NumPositionInsertErrors int `json:"positionInsertErrors"`
 }
 
-func CreateTables(db *sql.DB) (err error) {
-	createGamesTable := `
-	CREATE TABLE IF NOT EXISTS games (
-		id TEXT PRIMARY KEY,
-		url VARCHAR(255) NOT NULL,
-		time_class VARCHAR(20) NOT NULL,
-		time_control VARCHAR(25) NOT NULL,
-		white_player VARCHAR(50) NOT NULL,
-		black_player VARCHAR(50) NOT NULL,
-		white_rating INTEGER NOT NULL,
-		black_rating INTEGER NOT NULL,
-		winner VARCHAR(50),
-		result VARCHAR(25) NOT NULL
-	)
-	`
-
-	createPositionsTable := `
-	CREATE TABLE IF NOT EXISTS positions (
-		id TEXT PRIMARY KEY,
-		fen TEXT NOT NULL,
-		game_id TEXT NOT NULL
-	)
-	`
-
-	createUsersTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		username TEXT,
-		latest_archive TEXT
-	)
-	`
-
-	if _, err := db.Exec(createGamesTable); err != nil {
-		return fmt.Errorf("error creating games table: %w", err)
+// OpenUserDb opens the db of a user, creating it if needed, and migrates it to the latest schema
+func OpenUserDb(userId string) (db *sql.DB, err error) {
+	dbFilename := fmt.Sprintf("file:%s.db?_journal_mode=WAL&_synchronous=NORMAL", userId)
+	db, err = sql.Open("sqlite3", dbFilename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening db: %w", err)
 	}
-	if _, err := db.Exec(createPositionsTable); err != nil {
-		return fmt.Errorf("error creating positions table: %w", err)
+
+	if _, err := db.Exec("PRAGMA journal_mode=WAL;"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error enabling WAL: %w", err)
 	}
-	if _, err := db.Exec(createUsersTable); err != nil {
-		return fmt.Errorf("error creating users table: %w", err)
+
+	if err := Migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error migrating db %s: %w", userId, err)
 	}
 
 	return
 }
 
-func insertGame(tx *sql.Tx, gameStmt *sql.Stmt, fenStmt *sql.Stmt, game Game) (numPositionsInserted int, numPositionInsertErrors int, err error) {
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func durationMs(d *time.Duration) interface{} {
+	if d == nil {
+		return nil
+	}
+	return d.Milliseconds()
+}
+
+func insertGame(tx *sql.Tx, gameStmt *sql.Stmt, fenStmt *sql.Stmt, positionStmt *sql.Stmt, game Game) (numPositionsInserted int, numPositionInsertErrors int, err error) {
 	var winner interface{} = nil
 	result := game.WhitePlayer.Result
 	if game.WhitePlayer.Result == "win" {
@@ -75,6 +64,23 @@ func insertGame(tx *sql.Tx, gameStmt *sql.Stmt, fenStmt *sql.Stmt, game Game) (n
 		result = game.WhitePlayer.Result
 	}
 
+	var whiteAccuracy, blackAccuracy interface{} = nil, nil
+	if game.Accuracies != nil {
+		whiteAccuracy = game.Accuracies.White
+		blackAccuracy = game.Accuracies.Black
+	}
+
+	var startTime interface{} = nil
+	if game.StartTime != 0 {
+		startTime = game.StartTime
+	}
+
+	var eco, openingName interface{} = nil, nil
+	if game.Opening != nil {
+		eco = game.Opening.Eco
+		openingName = game.Opening.Name
+	}
+
 	_, err = tx.Stmt(gameStmt).Exec(
 		game.Id,
 		game.Url,
@@ -86,17 +92,47 @@ func insertGame(tx *sql.Tx, gameStmt *sql.Stmt, fenStmt *sql.Stmt, game Game) (n
 		game.BlackPlayer.Rating,
 		winner,
 		result,
+		game.EndTime,
+		startTime,
+		game.IsRated,
+		game.Rules,
+		game.EcoUrl,
+		whiteAccuracy,
+		blackAccuracy,
+		game.WhitePlayer.Id,
+		game.BlackPlayer.Id,
+		game.Tcn,
+		game.Pgn,
+		game.Variant,
+		eco,
+		openingName,
 	)
 	if err != nil {
 		err = fmt.Errorf("insert game error: %w", err)
 		return
 	}
 
-	for _, fen := range game.Fens {
-		hash := sha256.New()
-		hash.Write([]byte(fen))
-		hash.Write([]byte(game.Id))
-		if _, err := tx.Stmt(fenStmt).Exec(hash.Sum(nil), fen, game.Id); err != nil {
+	for _, position := range game.Positions {
+		// the fen is shared by every game that reaches the position
+		if _, err := tx.Stmt(fenStmt).Exec(position.FenId, position.Fen); err != nil {
+			numPositionInsertErrors++
+			continue
+		}
+
+		_, err := tx.Stmt(positionStmt).Exec(
+			game.Id,
+			position.Ply,
+			position.FenId,
+			position.SideToMove,
+			nullIfEmpty(position.San),
+			nullIfEmpty(position.Uci),
+			nullIfEmpty(position.PrevSan),
+			nullIfEmpty(position.PrevUci),
+			durationMs(position.Clock),
+			durationMs(position.TimeSpent),
+			position.Phase,
+		)
+		if err != nil {
 			numPositionInsertErrors++
 			continue
 		}
@@ -106,18 +142,18 @@ func insertGame(tx *sql.Tx, gameStmt *sql.Stmt, fenStmt *sql.Stmt, game Game) (n
 	return
 }
 
-func InsertUserData(db *sql.DB, userId string, username string, allGames []Game, archives []string) (statistics InsertStatistics, err error) {
-	numGamesInserted := 0
-	numPositionsInserted := 0
-	numGameInsertErrors := 0
-	numPositionInsertErrors := 0
-
-	tx, err := db.Begin()
-	if err != nil {
-		return statistics, fmt.Errorf("error starting initial transaction: %w", err)
-	}
+// gameInserter inserts games and their positions inside the transaction of the caller, counting them
+// in statistics
+type gameInserter struct {
+	gameStmt     *sql.Stmt
+	fenStmt      *sql.Stmt
+	positionStmt *sql.Stmt
+	statistics   InsertStatistics
+}
 
-	gameInsertStmt, err := db.Prepare(`
+func newGameInserter(db *sql.DB) (inserter *gameInserter, err error) {
+	inserter = &gameInserter{}
+	inserter.gameStmt, err = db.Prepare(`
 	INSERT OR IGNORE INTO games (
 		id, 
 		url, 
@@ -128,35 +164,93 @@ func InsertUserData(db *sql.DB, userId string, username string, allGames []Game,
 		white_rating,
 		black_rating,
 		winner,
-		result
-	) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+		result,
+		end_time,
+		start_time,
+		rated,
+		rules,
+		eco_url,
+		white_accuracy,
+		black_accuracy,
+		white_uuid,
+		black_uuid,
+		tcn,
+		pgn,
+		variant,
+		eco,
+		opening_name
+	) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
-		return statistics, fmt.Errorf("error preparing games insert: %w", err)
+		inserter.close()
+		return nil, fmt.Errorf("error preparing games insert: %w", err)
 	}
-	defer gameInsertStmt.Close()
-	fenInsertStmt, err := db.Prepare("INSERT OR IGNORE INTO positions (id, fen, game_id) VALUES(?, ?, ?)")
+	inserter.fenStmt, err = db.Prepare("INSERT OR IGNORE INTO fens (id, fen) VALUES(?, ?)")
 	if err != nil {
-		return statistics, fmt.Errorf("error preparing positions insert: %w", err)
+		inserter.close()
+		return nil, fmt.Errorf("error preparing fens insert: %w", err)
+	}
+	inserter.positionStmt, err = db.Prepare(`
+	INSERT OR IGNORE INTO game_positions (
+		game_id,
+		ply,
+		fen_id,
+		side_to_move,
+		san,
+		uci,
+		prev_san,
+		prev_uci,
+		clock_ms,
+		time_spent_ms,
+		phase
+	) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		inserter.close()
+		return nil, fmt.Errorf("error preparing positions insert: %w", err)
 	}
-	defer fenInsertStmt.Close()
 
-	for i, game := range allGames {
-		fmt.Printf("%d / %d games inserted\r", i+1, len(allGames))
-		if strings.Contains(game.Pgn, "[Variant \"") {
-			// variants tend to break pgn parser
-			continue
-		}
+	return
+}
 
-		currNumPositionsInserted, currNumPositionInsertErrors, err := insertGame(tx, gameInsertStmt, fenInsertStmt, game)
-		if err != nil {
-			numGameInsertErrors++
-		} else {
-			numGamesInserted++
+func (i *gameInserter) insert(tx *sql.Tx, game Game) {
+	numPositionsInserted, numPositionInsertErrors, err := insertGame(tx, i.gameStmt, i.fenStmt, i.positionStmt, game)
+	if err != nil {
+		i.statistics.NumGameInsertErrors++
+	} else {
+		i.statistics.NumGamesInserted++
+	}
+	i.statistics.NumPositionsInserted += numPositionsInserted
+	i.statistics.NumPositionInsertErrors += numPositionInsertErrors
+}
+
+func (i *gameInserter) close() {
+	for _, stmt := range []*sql.Stmt{i.gameStmt, i.fenStmt, i.positionStmt} {
+		if stmt != nil {
+			stmt.Close()
 		}
-		numPositionsInserted += currNumPositionsInserted
-		numPositionInsertErrors += currNumPositionInsertErrors
+	}
+}
+
+// InsertUserData inserts games as they arrive on games, committing every insertBatchSize games,
+// until games is closed
+func InsertUserData(db *sql.DB, userId string, username string, games <-chan Game, archives []string) (statistics InsertStatistics, err error) {
+	inserter, err := newGameInserter(db)
+	if err != nil {
+		return
+	}
+	defer inserter.close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return statistics, fmt.Errorf("error starting initial transaction: %w", err)
+	}
 
-		if i%insertBatchSize == 0 {
+	numGamesReceived := 0
+	for game := range games {
+		numGamesReceived++
+		fmt.Printf("%d games received\r", numGamesReceived)
+		inserter.insert(tx, game)
+
+		if numGamesReceived%insertBatchSize == 0 {
 			if err := tx.Commit(); err != nil {
 				return statistics, fmt.Errorf("error committing transaction: %w", err)
 			}
@@ -173,14 +267,6 @@ func InsertUserData(db *sql.DB, userId string, username string, allGames []Game,
 		return statistics, fmt.Errorf("error committing final transaction: %w", err)
 	}
 
-	fmt.Println("Indexing db...")
-	createPositionsIndex := `
-	CREATE INDEX IF NOT EXISTS fen_idx ON positions(fen)
-	`
-	if _, err := db.Exec(createPositionsIndex); err != nil {
-		return statistics, fmt.Errorf("error creating positions index: %w", err)
-	}
-
 	if len(archives) > 0 {
 		mostRecentArchive := archives[len(archives)-1]
 		insertUserStmt := "INSERT OR IGNORE INTO users (id, username, latest_archive) VALUES(?, ?, ?)"
@@ -190,15 +276,10 @@ func InsertUserData(db *sql.DB, userId string, username string, allGames []Game,
 		}
 	}
 
-	return InsertStatistics{
-		NumGamesInserted:        numGamesInserted,
-		NumGameInsertErrors:     numGameInsertErrors,
-		NumPositionsInserted:    numPositionsInserted,
-		NumPositionInsertErrors: numPositionInsertErrors,
-	}, nil
+	return inserter.statistics, nil
 }
 
-func LoadExistingDbs(dbMap *types.DBMap) (userIds []string, err error) {
+func LoadExistingDbs() (dbs map[string]*sql.DB, err error) {
 	cwd, err := os.Getwd()
 	if err != nil {
 		return nil, fmt.Errorf("error loading existing dbs: %w", err)
@@ -210,22 +291,17 @@ func LoadExistingDbs(dbMap *types.DBMap) (userIds []string, err error) {
 		return nil, fmt.Errorf("error loading existing dbs: %w", err)
 	}
 
+	dbs = make(map[string]*sql.DB)
 	for _, filepath := range existingDbs {
 		filepathParts := strings.Split(filepath, "/")
 		filenameWithExtension := filepathParts[len(filepathParts)-1]
-		dbFilename := fmt.Sprintf("file:%s?_journal_mode=WAL&_synchronous=NORMAL", filenameWithExtension)
-		db, err := sql.Open("sqlite3", dbFilename)
+		filenameWithoutExtension := filenameWithExtension[0 : len(filenameWithExtension)-3]
+		db, err := OpenUserDb(filenameWithoutExtension)
 		if err != nil {
 			return nil, fmt.Errorf("error loading existing dbs: %w", err)
 		}
 
-		if _, err := db.Exec("PRAGMA journal_mode=WAL;"); err != nil {
-			return nil, fmt.Errorf("error enabling WAL: %w", err)
-		}
-
-		filenameWithoutExtension := filenameWithExtension[0 : len(filenameWithExtension)-3]
-		(*dbMap)[filenameWithoutExtension] = types.NewLockedDB(db)
-		userIds = append(userIds, filenameWithoutExtension)
+		dbs[filenameWithoutExtension] = db
 	}
 
 	return
@@ -253,6 +329,52 @@ func GetMostRecentArchive(userId string, db *sql.DB) (archive string, err error)
 	return
 }
 
+// RecordArchiveResults remembers failed archives so they can be retried by the next update
+// and forgets previously failed archives that have now been downloaded
+func RecordArchiveResults(db *sql.DB, results []ArchiveResult) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting archive results transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, result := range results {
+		if result.Err != nil {
+			_, err = tx.Exec("INSERT OR REPLACE INTO failed_archives (url, error) VALUES(?, ?)", result.Url, result.Err.Error())
+		} else {
+			_, err = tx.Exec("DELETE FROM failed_archives WHERE url = ?", result.Url)
+		}
+		if err != nil {
+			return fmt.Errorf("error recording archive result: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing archive results: %w", err)
+	}
+
+	return
+}
+
+func GetFailedArchives(db *sql.DB) (archives []string, err error) {
+	rows, err := db.Query("SELECT url FROM failed_archives ORDER BY url")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var archive string
+		if err = rows.Scan(&archive); err != nil {
+			return
+		}
+		archives = append(archives, archive)
+	}
+	err = rows.Err()
+
+	return
+}
+
 /*
 	when loading initial dbs, set the setupstatus to pending
 	when the user calls setup, check if it is pending
@@ -0,0 +1,71 @@
+package model
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+)
+
+// eco.tsv has an eco code, opening name and the moves of its main line per row, after a header row. It's the
+// eco, name and pgn columns of the lichess chess-openings table (https://github.com/lichess-org/chess-openings).
+//
+//go:embed eco.tsv
+var ecoFile string
+
+type Opening struct {
+	Eco  string
+	Name string
+}
+
+// openings maps the hash of the final position of each line in eco.tsv to its opening, so openings
+// reached by transposition are still recognised
+var openings = mustLoadOpenings(ecoFile)
+
+func mustLoadOpenings(file string) map[int64]Opening {
+	openings, err := loadOpenings(file)
+	if err != nil {
+		panic(fmt.Sprintf("error loading eco table: %s", err))
+	}
+	return openings
+}
+
+func loadOpenings(file string) (openings map[int64]Opening, err error) {
+	openings = make(map[int64]Opening)
+	lines := strings.Split(strings.TrimSpace(file), "\n")
+	for i, line := range lines[1:] {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected 3 fields, found %d", i+2, len(fields))
+		}
+
+		pgnGame, err := ParsePgn(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+2, err)
+		}
+
+		b := NewBoard()
+		for _, move := range pgnGame.Moves {
+			m, err := b.ParseSan(move.San)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+2, err)
+			}
+			b.MakeMove(m)
+		}
+
+		// the first line to reach a position names it
+		if _, found := openings[b.Hash()]; !found {
+			openings[b.Hash()] = Opening{Eco: fields[0], Name: fields[1]}
+		}
+	}
+	return
+}
+
+// ClassifyOpening finds the named opening of the deepest position in fenIds, which are ordered by ply
+func ClassifyOpening(fenIds []int64) (opening Opening, ok bool) {
+	for i := len(fenIds) - 1; i >= 0; i-- {
+		if opening, ok = openings[fenIds[i]]; ok {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClassifyOpening(t *testing.T) {
+	tests := []struct {
+		moves string
+		eco   string
+		name  string
+	}{
+		{"e4 e5 Nf3 Nc6 Bc4", "C50", "Italian Game"},
+		{"e4 c5 Nf3 d6 d4 cxd4 Nxd4 Nf6 Nc3 a6 Bg5 e6 f4 Be7", "B98", "Sicilian Defense: Najdorf Variation"},
+		// the game goes on past the last move of the table's line, which still names it
+		{
+			"e4 e5 Nf3 Nc6 Bb5 a6 Ba4 Nf6 O-O Be7 Re1 b5 Bb3 d6 c3 O-O h3 Nb8 d4 Nbd7 Nbd2 Bb7 Bc2 c5 d5 Re8",
+			"C95", "Ruy Lopez: Closed, Breyer Defense",
+		},
+		// 1. Nf3 d5 2. d4 transposes into the queen's pawn game reached by 1. d4 d5 2. Nf3
+		{"Nf3 d5 d4", "D02", "Queen's Pawn Game: Zukertort Variation"},
+	}
+
+	for _, tt := range tests {
+		b := NewBoard()
+		fenIds := []int64{b.Hash()}
+		for _, san := range strings.Fields(tt.moves) {
+			m, err := b.ParseSan(san)
+			if err != nil {
+				t.Fatalf("%s in %s: %s", san, tt.moves, err)
+			}
+			b.MakeMove(m)
+			fenIds = append(fenIds, b.Hash())
+		}
+
+		opening, ok := ClassifyOpening(fenIds)
+		if !ok || opening != (Opening{tt.eco, tt.name}) {
+			t.Errorf("%s classified as %+v, %v, expected %s %s", tt.moves, opening, ok, tt.eco, tt.name)
+		}
+	}
+}
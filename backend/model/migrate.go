@@ -0,0 +1,469 @@
+package model
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrations are named <version>_<name>.sql and applied in version order, each in its own transaction.
+//
+// Columns added to existing tables are NULL on rows stored before their migration, and stay that way unless
+// the migration's data hook fills them in or the user is rebuilt. A rebuild can only regenerate games whose
+// archive is in the archive cache, which holds the archives downloaded since the cache was added, so users
+// set up before then keep the NULLs on their older games.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	up      string
+
+	// runs after up in the same transaction, for data changes sql can't express
+	data func(tx *sql.Tx) error
+}
+
+var dataMigrations = map[int]func(tx *sql.Tx) error{
+	6: migrateFens,
+	8: classifyOpenings,
+}
+
+type SchemaTooNewError struct {
+	Version       int
+	LatestVersion int
+}
+
+func (e *SchemaTooNewError) Error() string {
+	return fmt.Sprintf("db schema version %d is newer than the latest version %d known to this binary", e.Version, e.LatestVersion)
+}
+
+func loadMigrations() (migrations []migration, err error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	for _, entry := range entries {
+		filename := entry.Name()
+		versionStr, name, found := strings.Cut(strings.TrimSuffix(filename, ".sql"), "_")
+		if !found {
+			return nil, fmt.Errorf("invalid migration filename: %s", filename)
+		}
+
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", filename, err)
+		}
+
+		up, err := migrationFiles.ReadFile(path.Join("migrations", filename))
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", filename, err)
+		}
+
+		migrations = append(migrations, migration{
+			version: version,
+			name:    name,
+			up:      string(up),
+			data:    dataMigrations[version],
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration versions must be contiguous from 1, found %d at position %d", m.version, i+1)
+		}
+	}
+
+	return
+}
+
+func SchemaVersion(db *sql.DB) (version int, err error) {
+	err = db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return
+}
+
+// Migrate brings db up to the latest schema version, refusing to touch dbs written by a newer binary
+func Migrate(db *sql.DB) (err error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return
+	}
+
+	createMigrationsTable := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	)
+	`
+	if _, err := db.Exec(createMigrationsTable); err != nil {
+		return fmt.Errorf("error creating schema migrations table: %w", err)
+	}
+
+	currentVersion, err := SchemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("error getting schema version: %w", err)
+	}
+
+	latestVersion := len(migrations)
+	if currentVersion > latestVersion {
+		return &SchemaTooNewError{Version: currentVersion, LatestVersion: latestVersion}
+	}
+
+	for _, m := range migrations[currentVersion:] {
+		fmt.Printf("Applying migration %d (%s)...\n", m.version, m.name)
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("error applying migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+
+	return
+}
+
+func applyMigration(db *sql.DB, m migration) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(m.up); err != nil {
+		return
+	}
+	if m.data != nil {
+		if err = m.data(tx); err != nil {
+			return
+		}
+	}
+
+	insertMigration := "INSERT INTO schema_migrations (version, name, applied_at) VALUES(?, ?, ?)"
+	if _, err = tx.Exec(insertMigration, m.version, m.name, time.Now().Unix()); err != nil {
+		return
+	}
+
+	return tx.Commit()
+}
+
+// migrateFens copies positions into fens and game_positions. Rows stored since plies were recorded are copied
+// as they are. Older rows hold nothing but the fen after each move, without the starting position and with
+// repeated positions dropped, so the positions of those games are worked out again by legacyPositions.
+func migrateFens(tx *sql.Tx) (err error) {
+	rows, err := tx.Query(`
+	SELECT
+		p.fen,
+		p.game_id,
+		p.ply,
+		p.side_to_move,
+		p.san,
+		p.uci,
+		p.prev_san,
+		p.prev_uci,
+		p.clock_ms,
+		p.time_spent_ms,
+		p.phase,
+		g.pgn,
+		g.rules,
+		g.time_control
+	FROM positions p
+	LEFT JOIN games g ON g.id = p.game_id
+	ORDER BY p.game_id, p.ply, p.rowid
+	`)
+	if err != nil {
+		return fmt.Errorf("error querying positions: %w", err)
+	}
+	defer rows.Close()
+
+	fenStmt, err := tx.Prepare("INSERT OR IGNORE INTO fens (id, fen) VALUES(?, ?)")
+	if err != nil {
+		return fmt.Errorf("error preparing fens insert: %w", err)
+	}
+	defer fenStmt.Close()
+	positionStmt, err := tx.Prepare(`
+	INSERT OR IGNORE INTO game_positions (
+		game_id,
+		ply,
+		fen_id,
+		side_to_move,
+		san,
+		uci,
+		prev_san,
+		prev_uci,
+		clock_ms,
+		time_spent_ms,
+		phase
+	) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("error preparing game positions insert: %w", err)
+	}
+	defer positionStmt.Close()
+
+	numMigrated := 0
+	numInvalid := 0
+	numUnplaced := 0
+	insertPosition := func(fenId int64, fen string, args ...interface{}) error {
+		if _, err := fenStmt.Exec(fenId, fen); err != nil {
+			return fmt.Errorf("error inserting fen: %w", err)
+		}
+		if _, err := positionStmt.Exec(args...); err != nil {
+			return fmt.Errorf("error inserting game position: %w", err)
+		}
+		numMigrated++
+		if numMigrated%100000 == 0 {
+			fmt.Printf("%d positions migrated\r", numMigrated)
+		}
+		return nil
+	}
+
+	// the rows without a ply sort first, they're only migrated if the game has no rows with one
+	var legacyGame RawGame
+	var legacyBoards []*Board
+	migrateLegacyGame := func() error {
+		if len(legacyBoards) == 0 {
+			return nil
+		}
+		positions, unplaced := legacyPositions(legacyGame, legacyBoards)
+		legacyBoards = nil
+
+		for _, position := range positions {
+			err := insertPosition(
+				position.FenId,
+				position.Fen,
+				legacyGame.Id,
+				position.Ply,
+				position.FenId,
+				position.SideToMove,
+				nullIfEmpty(position.San),
+				nullIfEmpty(position.Uci),
+				nullIfEmpty(position.PrevSan),
+				nullIfEmpty(position.PrevUci),
+				durationMs(position.Clock),
+				durationMs(position.TimeSpent),
+				position.Phase,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		for _, b := range unplaced {
+			fenId := b.Hash()
+			if err := insertPosition(fenId, b.PositionFen(), legacyGame.Id, nil, fenId, b.Turn().String(), nil, nil, nil, nil, nil, nil, nil); err != nil {
+				return err
+			}
+		}
+		numUnplaced += len(unplaced)
+		return nil
+	}
+
+	for rows.Next() {
+		var fen string
+		var gameId string
+		var ply sql.NullInt64
+		var sideToMove, san, uci, prevSan, prevUci, phase sql.NullString
+		var clockMs, timeSpentMs sql.NullInt64
+		var pgn, rules, timeControl sql.NullString
+
+		if err := rows.Scan(&fen, &gameId, &ply, &sideToMove, &san, &uci, &prevSan, &prevUci, &clockMs, &timeSpentMs, &phase, &pgn, &rules, &timeControl); err != nil {
+			return fmt.Errorf("error reading position: %w", err)
+		}
+
+		if gameId != legacyGame.Id {
+			if err := migrateLegacyGame(); err != nil {
+				return err
+			}
+			legacyGame = RawGame{Id: gameId, Pgn: pgn.String, Rules: rules.String, TimeControl: timeControl.String}
+		}
+
+		b, err := ParseFen(fen)
+		if err != nil {
+			numInvalid++
+			continue
+		}
+
+		if !ply.Valid {
+			legacyBoards = append(legacyBoards, b)
+			continue
+		}
+		// the game was stored again once plies were recorded, which already covers the legacy rows
+		legacyBoards = nil
+
+		fenId := b.Hash()
+		if err := insertPosition(fenId, b.PositionFen(), gameId, ply, fenId, sideToMove, san, uci, prevSan, prevUci, clockMs, timeSpentMs, phase); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading positions: %w", err)
+	}
+	if err := migrateLegacyGame(); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d positions migrated, %d without a ply, %d skipped with invalid fens\n", numMigrated, numUnplaced, numInvalid)
+	return
+}
+
+// legacyPositions works out the positions of a game stored before plies were recorded from boards, the
+// position after each move with repeats dropped. The game is parsed again from its pgn when that was kept.
+// Otherwise boards are replayed from the standard starting position, the only one before variants were
+// stored, for as long as each is a legal move away from the last. The boards from the first one that isn't
+// are returned as unplaced, since their ply can't be known.
+func legacyPositions(rawGame RawGame, boards []*Board) (positions []Position, unplaced []*Board) {
+	if rawGame.Pgn != "" {
+		if game := parseGame(&rawGame); len(game.Positions) > 0 {
+			return game.Positions, nil
+		}
+	}
+
+	b := NewBoard()
+	for i, target := range boards {
+		m, ok := moveReaching(b, target)
+		if !ok {
+			if i == 0 {
+				// nothing ties the game to the starting position
+				return nil, boards
+			}
+			return positions, boards[i:]
+		}
+
+		if i == 0 {
+			positions = append(positions, newPosition(b, 0))
+		}
+		san, uci := b.San(m), b.Uci(m)
+		positions[i].San = san
+		positions[i].Uci = uci
+		b.MakeMove(m)
+
+		position := newPosition(b, i+1)
+		position.PrevSan = san
+		position.PrevUci = uci
+		positions = append(positions, position)
+	}
+	return positions, nil
+}
+
+// moveReaching finds the legal move from b to target. Only the squares the positions differ on take part in
+// the move, so the candidates are the mover's pieces that left one of them for another instead of every legal
+// move in the position.
+func moveReaching(b *Board, target *Board) (m Move, ok bool) {
+	hash := target.Hash()
+	reaches := func(m Move) bool {
+		if !b.isLegal(m) {
+			return false
+		}
+		after := *b
+		after.MakeMove(m)
+		return after.Hash() == hash
+	}
+
+	var froms, tos []int
+	for sq, piece := range b.squares {
+		if piece == target.squares[sq] {
+			continue
+		}
+		if piece != 0 && pieceColor(piece) == b.turn {
+			froms = append(froms, sq)
+		}
+		if arrived := target.squares[sq]; arrived != 0 && pieceColor(arrived) == b.turn {
+			tos = append(tos, sq)
+		}
+	}
+
+	for _, from := range froms {
+		piece := b.squares[from]
+		isPawn := pieceType(piece) == 'P'
+		for _, to := range tos {
+			m := Move{From: from, To: to}
+			if arrived := target.squares[to]; arrived != piece {
+				if !isPawn || rankOf(to) != 0 && rankOf(to) != 7 {
+					continue
+				}
+				m.Promotion = pieceType(arrived)
+			}
+			if isPawn && !b.canPawnMove(from, to) || !isPawn && !b.attacks(from, to) {
+				continue
+			}
+			if reaches(m) {
+				return m, true
+			}
+		}
+	}
+
+	for side := kingside; side <= queenside; side++ {
+		if m, err := b.castleMove(side); err == nil && reaches(m) {
+			return m, true
+		}
+	}
+	return m, false
+}
+
+// classifyOpenings fills in the opening of the standard games stored before games were classified
+func classifyOpenings(tx *sql.Tx) (err error) {
+	rows, err := tx.Query(`
+	SELECT p.game_id, p.fen_id
+	FROM game_positions p
+	JOIN games g ON g.id = p.game_id
+	WHERE g.variant = ? AND p.ply IS NOT NULL
+	ORDER BY p.game_id, p.ply
+	`, VariantStandard)
+	if err != nil {
+		return fmt.Errorf("error querying game positions: %w", err)
+	}
+	defer rows.Close()
+
+	updateStmt, err := tx.Prepare("UPDATE games SET eco = ?, opening_name = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("error preparing games update: %w", err)
+	}
+	defer updateStmt.Close()
+
+	numClassified := 0
+	classify := func(gameId string, fenIds []int64) error {
+		opening, ok := ClassifyOpening(fenIds)
+		if !ok {
+			return nil
+		}
+		if _, err := updateStmt.Exec(opening.Eco, opening.Name, gameId); err != nil {
+			return fmt.Errorf("error updating game: %w", err)
+		}
+		numClassified++
+		return nil
+	}
+
+	gameId := ""
+	var fenIds []int64
+	for rows.Next() {
+		var currGameId string
+		var fenId int64
+		if err := rows.Scan(&currGameId, &fenId); err != nil {
+			return fmt.Errorf("error reading game position: %w", err)
+		}
+
+		if currGameId != gameId {
+			if err := classify(gameId, fenIds); err != nil {
+				return err
+			}
+			gameId = currGameId
+			fenIds = fenIds[:0]
+		}
+		fenIds = append(fenIds, fenId)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading game positions: %w", err)
+	}
+	if err := classify(gameId, fenIds); err != nil {
+		return err
+	}
+
+	fmt.Printf("%d games classified\n", numClassified)
+	return
+}
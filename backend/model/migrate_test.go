@@ -0,0 +1,227 @@
+package model
+
+import (
+	"backend/chesscomtest"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// baselineSchema is the schema dbs were created with before migrations existed
+const baselineSchema = `
+CREATE TABLE games (
+	id TEXT PRIMARY KEY,
+	url VARCHAR(255) NOT NULL,
+	time_class VARCHAR(20) NOT NULL,
+	time_control VARCHAR(25) NOT NULL,
+	white_player VARCHAR(50) NOT NULL,
+	black_player VARCHAR(50) NOT NULL,
+	white_rating INTEGER NOT NULL,
+	black_rating INTEGER NOT NULL,
+	winner VARCHAR(50),
+	result VARCHAR(25) NOT NULL
+);
+CREATE TABLE positions (
+	id TEXT PRIMARY KEY,
+	fen TEXT NOT NULL,
+	game_id TEXT NOT NULL
+);
+CREATE TABLE users (
+	id TEXT PRIMARY KEY,
+	username TEXT,
+	latest_archive TEXT
+);
+`
+
+// the baseline stored the fen after each move without its move counters, with the en passant square set
+// after every double push, and dropped positions the game had already reached
+var baselineGames = map[string][]string{
+	// 1. e4 e5 2. Qh5 Nc6 3. Bc4 Nf6 4. Qxf7#
+	"scholars": {
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3",
+		"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6",
+		"rnbqkbnr/pppp1ppp/8/4p2Q/4P3/8/PPPP1PPP/RNB1KBNR b KQkq -",
+		"r1bqkbnr/pppp1ppp/2n5/4p2Q/4P3/8/PPPP1PPP/RNB1KBNR w KQkq -",
+		"r1bqkbnr/pppp1ppp/2n5/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR b KQkq -",
+		"r1bqkb1r/pppp1ppp/2n2n2/4p2Q/2B1P3/8/PPPP1PPP/RNB1K1NR w KQkq -",
+		"r1bqkb1r/pppp1Qpp/2n2n2/4p3/2B1P3/8/PPPP1PPP/RNB1K1NR b KQkq -",
+	},
+	// 1. Nf3 Nf6 2. Ng1 Ng8 3. Nf3 Nf6 4. e4, the positions after 3. Nf3 and 3... Nf6 are repeats
+	"repetition": {
+		"rnbqkbnr/pppppppp/8/8/8/5N2/PPPPPPPP/RNBQKB1R b KQkq -",
+		"rnbqkb1r/pppppppp/5n2/8/8/5N2/PPPPPPPP/RNBQKB1R w KQkq -",
+		"rnbqkb1r/pppppppp/5n2/8/8/8/PPPPPPPP/RNBQKBNR b KQkq -",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -",
+		"rnbqkb1r/pppppppp/5n2/8/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq e3",
+	},
+	// nothing a move away from the starting position
+	"unreachable": {
+		"4k3/8/8/8/8/8/8/4K3 w - -",
+		"4k3/8/8/8/8/8/4K3/8 b - -",
+	},
+}
+
+// createBaselineDb writes a db the way the baseline did, with numCopies copies of baselineGames, and returns
+// the name OpenUserDb opens it by
+func createBaselineDb(tb testing.TB, numCopies int) string {
+	tb.Helper()
+	name := filepath.Join(tb.TempDir(), "user")
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s.db", name))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(baselineSchema); err != nil {
+		tb.Fatalf("error creating baseline schema: %s", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer tx.Rollback()
+	for i := 0; i < numCopies; i++ {
+		for baselineId, fens := range baselineGames {
+			gameId := baselineId
+			if i > 0 {
+				gameId = fmt.Sprintf("%s%d", baselineId, i)
+			}
+			_, err := tx.Exec(
+				"INSERT INTO games VALUES(?, ?, 'blitz', '180', 'bob', 'alice', 1500, 1490, 'bob', 'resigned')",
+				gameId, "https://www.chess.com/game/live/"+gameId,
+			)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			for _, fen := range fens {
+				if _, err := tx.Exec("INSERT INTO positions VALUES(?, ?, ?)", fen+gameId, fen, gameId); err != nil {
+					tb.Fatal(err)
+				}
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tb.Fatal(err)
+	}
+	return name
+}
+
+func TestMigrateBaselinePositions(t *testing.T) {
+	db, err := OpenUserDb(createBaselineDb(t, 1))
+	if err != nil {
+		t.Fatalf("error migrating baseline db: %s", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		gameId string
+		// ply:san for each position, "-" for a NULL ply or san
+		positions string
+		eco       sql.NullString
+	}{
+		{"scholars", "0:e4 1:e5 2:Qh5 3:Nc6 4:Bc4 5:Nf6 6:Qxf7# 7:-", sql.NullString{String: "C20", Valid: true}},
+		{"repetition", "0:Nf3 1:Nf6 2:Ng1 3:Ng8 4:- -:-", sql.NullString{String: "A05", Valid: true}},
+		{"unreachable", "-:- -:-", sql.NullString{}},
+	}
+
+	for _, tt := range tests {
+		rows, err := db.Query(`
+		SELECT p.ply, p.san, p.fen_id
+		FROM game_positions p
+		WHERE p.game_id = ?
+		ORDER BY p.ply IS NULL, p.ply, p.rowid
+		`, tt.gameId)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var positions []string
+		var fenIds []int64
+		for rows.Next() {
+			var ply sql.NullInt64
+			var san sql.NullString
+			var fenId int64
+			if err := rows.Scan(&ply, &san, &fenId); err != nil {
+				t.Fatal(err)
+			}
+
+			position := "-:"
+			if ply.Valid {
+				position = fmt.Sprintf("%d:", ply.Int64)
+			}
+			if san.Valid {
+				position += san.String
+			} else {
+				position += "-"
+			}
+			positions = append(positions, position)
+			fenIds = append(fenIds, fenId)
+		}
+		rows.Close()
+
+		if got := strings.Join(positions, " "); got != tt.positions {
+			t.Errorf("game %s migrated to %s, expected %s", tt.gameId, got, tt.positions)
+		}
+
+		// every stored position is kept in the order it was played, after the starting position if it was added
+		if len(fenIds) > 0 && strings.HasPrefix(positions[0], "0:") {
+			fenIds = fenIds[1:]
+		}
+		var legacyFenIds []int64
+		for _, fen := range baselineGames[tt.gameId] {
+			fenId, err := FenHash(fen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			legacyFenIds = append(legacyFenIds, fenId)
+		}
+		if fmt.Sprint(fenIds) != fmt.Sprint(legacyFenIds) {
+			t.Errorf("game %s kept positions %v, expected %v", tt.gameId, fenIds, legacyFenIds)
+		}
+
+		var eco sql.NullString
+		if err := db.QueryRow("SELECT eco FROM games WHERE id = ?", tt.gameId).Scan(&eco); err != nil {
+			t.Fatal(err)
+		}
+		if eco != tt.eco {
+			t.Errorf("game %s classified as %v, expected %v", tt.gameId, eco, tt.eco)
+		}
+	}
+}
+
+func TestLegacyPositionsFromPgn(t *testing.T) {
+	var boards []*Board
+	for _, fen := range baselineGames["scholars"] {
+		b, err := ParseFen(fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		boards = append(boards, b)
+	}
+
+	rawGame := RawGame{Id: "scholars", Pgn: chesscomtest.ScholarsMatePgn, TimeControl: "180"}
+	positions, unplaced := legacyPositions(rawGame, boards[:2])
+	if len(positions) != 8 || len(unplaced) != 0 {
+		t.Fatalf("legacyPositions returned %d positions and %d unplaced, expected the 8 positions of the pgn", len(positions), len(unplaced))
+	}
+	if last := positions[7]; last.Clock == nil || last.PrevSan != "Qxf7#" || last.FenId != boards[6].Hash() {
+		t.Errorf("final position %+v, expected it to be reached by Qxf7# with a clock", last)
+	}
+}
+
+// BenchmarkMigrateBaselinePositions migrates a baseline db of 50k games, the size of the longest histories
+func BenchmarkMigrateBaselinePositions(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		name := createBaselineDb(b, 50000/len(baselineGames))
+		b.StartTimer()
+
+		db, err := OpenUserDb(name)
+		if err != nil {
+			b.Fatalf("error migrating baseline db: %s", err)
+		}
+		db.Close()
+	}
+}
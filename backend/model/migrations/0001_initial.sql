@@ -0,0 +1,30 @@
+-- dbs created before migrations existed already have these tables
+CREATE TABLE IF NOT EXISTS games (
+	id TEXT PRIMARY KEY,
+	url VARCHAR(255) NOT NULL,
+	time_class VARCHAR(20) NOT NULL,
+	time_control VARCHAR(25) NOT NULL,
+	white_player VARCHAR(50) NOT NULL,
+	black_player VARCHAR(50) NOT NULL,
+	white_rating INTEGER NOT NULL,
+	black_rating INTEGER NOT NULL,
+	winner VARCHAR(50),
+	result VARCHAR(25) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS positions (
+	id TEXT PRIMARY KEY,
+	fen TEXT NOT NULL,
+	game_id TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	username TEXT,
+	latest_archive TEXT
+);
+
+CREATE TABLE IF NOT EXISTS failed_archives (
+	url TEXT PRIMARY KEY,
+	error TEXT NOT NULL
+);
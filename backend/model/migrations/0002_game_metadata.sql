@@ -0,0 +1,13 @@
+ALTER TABLE games ADD COLUMN end_time INTEGER;
+ALTER TABLE games ADD COLUMN start_time INTEGER;
+ALTER TABLE games ADD COLUMN rated BOOLEAN;
+ALTER TABLE games ADD COLUMN rules VARCHAR(25);
+ALTER TABLE games ADD COLUMN eco_url VARCHAR(255);
+ALTER TABLE games ADD COLUMN white_accuracy REAL;
+ALTER TABLE games ADD COLUMN black_accuracy REAL;
+ALTER TABLE games ADD COLUMN white_uuid TEXT;
+ALTER TABLE games ADD COLUMN black_uuid TEXT;
+ALTER TABLE games ADD COLUMN tcn TEXT;
+ALTER TABLE games ADD COLUMN pgn TEXT;
+
+CREATE INDEX IF NOT EXISTS games_end_time_idx ON games(end_time);
@@ -0,0 +1,2 @@
+-- variant games used to be skipped entirely, so every existing game is standard chess
+ALTER TABLE games ADD COLUMN variant VARCHAR(25) NOT NULL DEFAULT 'standard';
@@ -0,0 +1,8 @@
+ALTER TABLE positions ADD COLUMN ply INTEGER;
+ALTER TABLE positions ADD COLUMN side_to_move VARCHAR(5);
+ALTER TABLE positions ADD COLUMN san VARCHAR(10);
+ALTER TABLE positions ADD COLUMN uci VARCHAR(5);
+ALTER TABLE positions ADD COLUMN prev_san VARCHAR(10);
+ALTER TABLE positions ADD COLUMN prev_uci VARCHAR(5);
+
+CREATE INDEX IF NOT EXISTS positions_game_ply_idx ON positions(game_id, ply);
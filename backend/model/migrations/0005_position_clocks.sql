@@ -0,0 +1,3 @@
+ALTER TABLE positions ADD COLUMN clock_ms INTEGER;
+ALTER TABLE positions ADD COLUMN time_spent_ms INTEGER;
+ALTER TABLE positions ADD COLUMN phase VARCHAR(10);
@@ -0,0 +1,24 @@
+-- every distinct position is stored once, keyed by its Zobrist hash. The rows of positions are copied
+-- over by migrateFens since the hash can't be computed in sql. ply is NULL for the positions of old games
+-- that couldn't be placed in the game, their rowid keeps them in the order they were played.
+CREATE TABLE fens (
+	id INTEGER PRIMARY KEY,
+	fen TEXT NOT NULL
+);
+
+CREATE TABLE game_positions (
+	game_id TEXT NOT NULL,
+	ply INTEGER,
+	fen_id INTEGER NOT NULL,
+	side_to_move VARCHAR(5),
+	san VARCHAR(10),
+	uci VARCHAR(5),
+	prev_san VARCHAR(10),
+	prev_uci VARCHAR(5),
+	clock_ms INTEGER,
+	time_spent_ms INTEGER,
+	phase VARCHAR(10)
+);
+
+CREATE UNIQUE INDEX game_positions_game_ply_idx ON game_positions(game_id, ply);
+CREATE INDEX game_positions_fen_idx ON game_positions(fen_id);
@@ -0,0 +1,2 @@
+-- replaced by fens and game_positions, dropping it also drops fen_idx and positions_game_ply_idx
+DROP TABLE positions;
@@ -0,0 +1,5 @@
+-- existing games are classified by classifyOpenings
+ALTER TABLE games ADD COLUMN eco VARCHAR(3);
+ALTER TABLE games ADD COLUMN opening_name TEXT;
+
+CREATE INDEX IF NOT EXISTS games_eco_idx ON games(eco);
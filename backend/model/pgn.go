@@ -0,0 +1,178 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+type PgnMove struct {
+	San string
+
+	// comment following the move, chess.com puts the clock here
+	Comment string
+}
+
+type PgnGame struct {
+	Tags  map[string]string
+	Moves []PgnMove
+}
+
+// ParsePgn splits a single game into its tag pairs and mainline moves. Move numbers, NAGs,
+// results and variations are dropped.
+func ParsePgn(pgnStr string) (game PgnGame, err error) {
+	game.Tags = make(map[string]string)
+
+	movetext := pgnStr
+	for {
+		trimmed := strings.TrimLeft(movetext, " \t\r\n")
+		if !strings.HasPrefix(trimmed, "[") {
+			movetext = trimmed
+			break
+		}
+
+		end := strings.IndexByte(trimmed, '\n')
+		line := trimmed
+		if end >= 0 {
+			line, movetext = trimmed[:end], trimmed[end+1:]
+		} else {
+			movetext = ""
+		}
+
+		key, value, err := parseTag(strings.TrimSpace(line))
+		if err != nil {
+			return game, err
+		}
+		game.Tags[key] = value
+	}
+
+	game.Moves, err = parseMovetext(movetext)
+	return
+}
+
+func parseTag(line string) (key string, value string, err error) {
+	if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+		return "", "", fmt.Errorf("invalid pgn tag: %s", line)
+	}
+
+	key, value, found := strings.Cut(line[1:len(line)-1], " ")
+	value = strings.TrimSpace(value)
+	if !found || len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return "", "", fmt.Errorf("invalid pgn tag: %s", line)
+	}
+
+	value = strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+	return key, value, nil
+}
+
+func isResultToken(token string) bool {
+	return token == "1-0" || token == "0-1" || token == "1/2-1/2" || token == "*"
+}
+
+func parseMovetext(movetext string) (moves []PgnMove, err error) {
+	variationDepth := 0
+	for i := 0; i < len(movetext); {
+		c := movetext[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '{':
+			end := strings.IndexByte(movetext[i:], '}')
+			if end < 0 {
+				return moves, fmt.Errorf("unterminated pgn comment")
+			}
+			if variationDepth == 0 && len(moves) > 0 {
+				comment := strings.TrimSpace(movetext[i+1 : i+end])
+				last := &moves[len(moves)-1]
+				if last.Comment != "" {
+					comment = last.Comment + " " + comment
+				}
+				last.Comment = comment
+			}
+			i += end + 1
+		case c == ';':
+			end := strings.IndexByte(movetext[i:], '\n')
+			if end < 0 {
+				i = len(movetext)
+			} else {
+				i += end + 1
+			}
+		case c == '(':
+			variationDepth++
+			i++
+		case c == ')':
+			variationDepth--
+			i++
+		default:
+			end := i
+			for end < len(movetext) && strings.IndexByte(" \t\r\n{}();", movetext[end]) < 0 {
+				end++
+			}
+			token := movetext[i:end]
+			i = end
+
+			// strip move numbers such as "12." and "12..." that are glued to the move, and annotations
+			if j := strings.LastIndexByte(token, '.'); j >= 0 {
+				token = token[j+1:]
+			}
+			token = strings.TrimRight(token, "!?")
+			if token == "" || token[0] == '$' || isResultToken(token) || variationDepth > 0 {
+				continue
+			}
+
+			moves = append(moves, PgnMove{San: token})
+		}
+	}
+
+	return
+}
+
+var tagEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+type PgnTag struct {
+	Key   string
+	Value string
+}
+
+// SetPgnTags gives pgnStr with the values of tags replaced, keeping the order of the existing tag pairs.
+// Tags the game doesn't have yet are added after them. The movetext is left untouched.
+func SetPgnTags(pgnStr string, tags []PgnTag) (string, error) {
+	var lines []string
+	index := make(map[string]int)
+
+	movetext := pgnStr
+	for {
+		trimmed := strings.TrimLeft(movetext, " \t\r\n")
+		if !strings.HasPrefix(trimmed, "[") {
+			movetext = trimmed
+			break
+		}
+
+		end := strings.IndexByte(trimmed, '\n')
+		line := trimmed
+		if end >= 0 {
+			line, movetext = trimmed[:end], trimmed[end+1:]
+		} else {
+			movetext = ""
+		}
+
+		line = strings.TrimSpace(line)
+		key, _, err := parseTag(line)
+		if err != nil {
+			return "", err
+		}
+		index[key] = len(lines)
+		lines = append(lines, line)
+	}
+
+	for _, tag := range tags {
+		line := fmt.Sprintf(`[%s "%s"]`, tag.Key, tagEscaper.Replace(tag.Value))
+		if i, ok := index[tag.Key]; ok {
+			lines[i] = line
+		} else {
+			index[tag.Key] = len(lines)
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n") + "\n\n" + strings.TrimRight(movetext, " \t\r\n") + "\n", nil
+}
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePgn(t *testing.T) {
+	tests := []struct {
+		name  string
+		pgn   string
+		tags  map[string]string
+		moves []PgnMove
+	}{
+		{
+			"tags and clocks",
+			"[Event \"Live Chess\"]\n[White \"Bob \\\"the\\\" Builder\"]\n\n1. e4 {[%clk 0:02:58]} 1... e5 {[%clk 0:02:57.3]} 2. Nf3 1-0\n",
+			map[string]string{"Event": "Live Chess", "White": `Bob "the" Builder`},
+			[]PgnMove{{"e4", "[%clk 0:02:58]"}, {"e5", "[%clk 0:02:57.3]"}, {"Nf3", ""}},
+		},
+		{
+			"comments on one move are joined",
+			"1. e4 {best by test} {[%clk 0:00:59]} e5 *",
+			map[string]string{},
+			[]PgnMove{{"e4", "best by test [%clk 0:00:59]"}, {"e5", ""}},
+		},
+		{
+			"comment before the first move",
+			"{opening comment} 1. d4 d5 1/2-1/2",
+			map[string]string{},
+			[]PgnMove{{"d4", ""}, {"d5", ""}},
+		},
+		{
+			"variations",
+			"1. e4 e5 (1... c5 2. Nf3 (2. c3 {alapin}) d6) 2. Nf3 {mainline} 0-1",
+			map[string]string{},
+			[]PgnMove{{"e4", ""}, {"e5", ""}, {"Nf3", "mainline"}},
+		},
+		{
+			"nags and annotations",
+			"1. e4 $1 e5 $2 2. Nf3!! Nc6?! 3. Bb5!? a6?? *",
+			map[string]string{},
+			[]PgnMove{{"e4", ""}, {"e5", ""}, {"Nf3", ""}, {"Nc6", ""}, {"Bb5", ""}, {"a6", ""}},
+		},
+		{
+			"move numbers glued to moves",
+			"1.e4 1...e5 2.Nf3 2...Nc6 1-0",
+			map[string]string{},
+			[]PgnMove{{"e4", ""}, {"e5", ""}, {"Nf3", ""}, {"Nc6", ""}},
+		},
+		{
+			"line comments",
+			"1. e4 ; the king's pawn\ne5 2. Qh5 Nc6 3. Bc4 Nf6 4. Qxf7# 1-0",
+			map[string]string{},
+			[]PgnMove{{"e4", ""}, {"e5", ""}, {"Qh5", ""}, {"Nc6", ""}, {"Bc4", ""}, {"Nf6", ""}, {"Qxf7#", ""}},
+		},
+		{
+			"checks and promotions",
+			"1. e8=Q+ Kxe8 2. fxg8=N# 1-0",
+			map[string]string{},
+			[]PgnMove{{"e8=Q+", ""}, {"Kxe8", ""}, {"fxg8=N#", ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			game, err := ParsePgn(tt.pgn)
+			if err != nil {
+				t.Fatalf("ParsePgn: %s", err)
+			}
+			if !reflect.DeepEqual(game.Tags, tt.tags) {
+				t.Errorf("tags %v, expected %v", game.Tags, tt.tags)
+			}
+			if !reflect.DeepEqual(game.Moves, tt.moves) {
+				t.Errorf("moves %v, expected %v", game.Moves, tt.moves)
+			}
+		})
+	}
+}
+
+func TestParsePgnErrors(t *testing.T) {
+	for _, pgn := range []string{
+		"[Event Live Chess]\n\n1. e4 *",
+		"[Event \"Live Chess\"\n\n1. e4 *",
+		"1. e4 {unterminated",
+	} {
+		if _, err := ParsePgn(pgn); err == nil {
+			t.Errorf("ParsePgn(%q) succeeded", pgn)
+		}
+	}
+}
+
+func TestSetPgnTags(t *testing.T) {
+	pgn := "[Event \"Live Chess\"]\n[ECO \"C20\"]\n\n1. e4 e5 1-0\n\n"
+	tags := []PgnTag{{"ECO", "C44"}, {"Opening", `King's Pawn "Game"`}}
+
+	got, err := SetPgnTags(pgn, tags)
+	if err != nil {
+		t.Fatalf("SetPgnTags: %s", err)
+	}
+	expected := "[Event \"Live Chess\"]\n[ECO \"C44\"]\n[Opening \"King's Pawn \\\"Game\\\"\"]\n\n1. e4 e5 1-0\n"
+	if got != expected {
+		t.Errorf("SetPgnTags = %q, expected %q", got, expected)
+	}
+
+	game, err := ParsePgn(got)
+	if err != nil || game.Tags["Opening"] != `King's Pawn "Game"` {
+		t.Errorf("ParsePgn of the tagged pgn returned %v, %v", game.Tags, err)
+	}
+}
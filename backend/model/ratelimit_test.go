@@ -0,0 +1,123 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter whose clock only moves when the returned function advances it
+func newTestLimiter(rate float64, burst int) (l *RateLimiter, advance func(time.Duration)) {
+	l = NewRateLimiter(rate, burst)
+	now := l.last
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRateLimiterPacing(t *testing.T) {
+	l, advance := newTestLimiter(2, 3)
+
+	// the burst is free, then tokens are due every half second
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		delays = append(delays, l.reserve())
+	}
+	expected := []time.Duration{0, 0, 0, 500 * time.Millisecond, time.Second}
+	for i := range expected {
+		if delays[i] != expected[i] {
+			t.Fatalf("delays %v, expected %v", delays, expected)
+		}
+	}
+
+	// the two reserved tokens refill first
+	advance(1250 * time.Millisecond)
+	if delay := l.reserve(); delay != 250*time.Millisecond {
+		t.Errorf("delay %v after 1.25s, expected 250ms", delay)
+	}
+
+	// a long pause only refills the burst
+	advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if delay := l.reserve(); delay != 0 {
+			t.Errorf("token %d after an hour delayed %v, expected none", i, delay)
+		}
+	}
+	if delay := l.reserve(); delay != 500*time.Millisecond {
+		t.Errorf("token after the burst delayed %v, expected 500ms", delay)
+	}
+}
+
+func TestRateLimiterCancelRefund(t *testing.T) {
+	l, _ := newTestLimiter(1, 1)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("first Wait: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait with a cancelled context returned %v", err)
+	}
+
+	// the cancelled wait handed its token back, so the next one is due after one token rather than two
+	if delay := l.reserve(); delay != time.Second {
+		t.Errorf("delay %v after the cancelled wait, expected 1s", delay)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l := NewRateLimiter(100, 1)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("4 waits at 100 per second took %v, expected at least 30ms", elapsed)
+	}
+
+	var unlimited *RateLimiter
+	if err := unlimited.Wait(context.Background()); err != nil {
+		t.Errorf("Wait without a limiter: %s", err)
+	}
+}
+
+func TestStreamGamesWorkers(t *testing.T) {
+	const workers = 3
+	var mu sync.Mutex
+	var running, maxRunning int
+	readArchive := func(ctx context.Context, url string, rawGames chan<- RawGame) (int, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return 0, nil
+	}
+
+	archives := make([]string, 12)
+	for i := range archives {
+		archives[i] = string(rune('a' + i))
+	}
+	games := make(chan Game)
+	result := streamGames(context.Background(), archives, workers, readArchive, games)
+
+	if maxRunning != workers {
+		t.Errorf("%d archives read at once, expected %d", maxRunning, workers)
+	}
+	for i, archive := range result.Archives {
+		if archive.Url != archives[i] || archive.Err != nil {
+			t.Errorf("result %d is %+v, expected %s without an error", i, archive, archives[i])
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+const rebuildGameBufferSize = 1024
+
+func GetUsername(userId string, db *sql.DB) (username string, err error) {
+	err = db.QueryRow("SELECT username FROM users WHERE id = ?", userId).Scan(&username)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("no user entry for %s", userId)
+	}
+	return
+}
+
+// RebuildUserData clears the tables derived from the raw games and regenerates them from the archive cache,
+// picking up any changes to how games are parsed and inserted since the data was first stored. The clear and
+// the inserts share one transaction, so the previous data is kept if anything fails part way or the cache
+// doesn't hold every stored game.
+func RebuildUserData(db *sql.DB, userId string, cache *ArchiveCache) (statistics InsertStatistics, err error) {
+	username, err := GetUsername(userId, db)
+	if err != nil {
+		return statistics, fmt.Errorf("error getting username: %w", err)
+	}
+
+	archives, err := cache.Archives(username)
+	if err != nil {
+		return statistics, fmt.Errorf("error listing cached archives: %w", err)
+	}
+	if len(archives) == 0 {
+		// refuse to drop anything that can't be regenerated
+		return statistics, fmt.Errorf("no cached archives for %s", username)
+	}
+	// archive urls end in year/month so this is chronological
+	sort.Strings(archives)
+
+	inserter, err := newGameInserter(db)
+	if err != nil {
+		return
+	}
+	defer inserter.close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return statistics, fmt.Errorf("error starting rebuild transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var numGamesStored int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM games").Scan(&numGamesStored); err != nil {
+		return statistics, fmt.Errorf("error counting stored games: %w", err)
+	}
+
+	fmt.Printf("Rebuilding %s from %d cached archives...\n", username, len(archives))
+	// the schema belongs to the migrations, so only the rows are thrown away
+	if _, err := tx.Exec("DELETE FROM game_positions"); err != nil {
+		return statistics, fmt.Errorf("error clearing game positions table: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM fens"); err != nil {
+		return statistics, fmt.Errorf("error clearing fens table: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM games"); err != nil {
+		return statistics, fmt.Errorf("error clearing games table: %w", err)
+	}
+
+	games := make(chan Game, rebuildGameBufferSize)
+	gamesResultCh := make(chan GamesResult, 1)
+	go func() {
+		gamesResultCh <- cache.GetAllGames(context.Background(), archives, games)
+	}()
+
+	numGamesReceived := 0
+	for game := range games {
+		numGamesReceived++
+		fmt.Printf("%d games received\r", numGamesReceived)
+		inserter.insert(tx, game)
+	}
+	fmt.Println()
+	gamesResult := <-gamesResultCh
+
+	// a cached archive that can't be read anymore would lose its games for good
+	if failed := gamesResult.Failed(); len(failed) > 0 {
+		for _, archive := range failed {
+			fmt.Printf("Error rebuilding from cached archive %s: %s\n", archive.Url, archive.Err)
+		}
+		return statistics, fmt.Errorf("%d / %d cached archives failed to load", len(failed), len(archives))
+	}
+
+	// archives downloaded before the cache existed aren't in it, and their games can't be regenerated
+	if inserter.statistics.NumGamesInserted < numGamesStored {
+		return statistics, fmt.Errorf("only %d of %d stored games are in the archive cache", inserter.statistics.NumGamesInserted, numGamesStored)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return statistics, fmt.Errorf("error committing rebuild: %w", err)
+	}
+
+	return inserter.statistics, nil
+}
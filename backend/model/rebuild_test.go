@@ -0,0 +1,76 @@
+package model
+
+import (
+	"backend/chesscomtest"
+	"database/sql"
+	"os"
+	"testing"
+)
+
+// numRows counts the rows of table
+func numRows(t *testing.T, db *sql.DB, table string) (count int) {
+	t.Helper()
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatalf("error counting %s: %s", table, err)
+	}
+	return
+}
+
+func TestRebuildUserData(t *testing.T) {
+	server := chesscomtest.NewBobServer(t)
+	cache := NewArchiveCache(t.TempDir())
+	client := newTestClient(server.URL, cache)
+
+	archives, err := client.ListArchives("bob")
+	if err != nil {
+		t.Fatalf("ListArchives: %s", err)
+	}
+
+	db := openTestDb(t)
+	ingest(t, client, db, archives)
+	numPositions := numRows(t, db, "game_positions")
+
+	stats, err := RebuildUserData(db, "user", cache)
+	if err != nil {
+		t.Fatalf("RebuildUserData: %s", err)
+	}
+	if stats.NumGamesInserted != 4 || numRows(t, db, "games") != 4 || numRows(t, db, "game_positions") != numPositions {
+		t.Errorf("rebuild inserted %+v, expected the 4 games and %d positions back", stats, numPositions)
+	}
+
+	// a cached archive that can't be read fails the rebuild without losing the games stored from it
+	if err := os.WriteFile(cache.dataPath(archives[1]), []byte("not gzip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RebuildUserData(db, "user", cache); err == nil {
+		t.Errorf("RebuildUserData succeeded with a corrupt cached archive")
+	}
+	if numRows(t, db, "games") != 4 || numRows(t, db, "game_positions") != numPositions {
+		t.Errorf("failed rebuild left %d games and %d positions, expected 4 and %d",
+			numRows(t, db, "games"), numRows(t, db, "game_positions"), numPositions)
+	}
+}
+
+func TestRebuildUserDataUncachedGames(t *testing.T) {
+	server := chesscomtest.NewBobServer(t)
+	client := newTestClient(server.URL, nil)
+
+	archives, err := client.ListArchives("bob")
+	if err != nil {
+		t.Fatalf("ListArchives: %s", err)
+	}
+
+	// the first archive is downloaded before the cache existed
+	db := openTestDb(t)
+	ingest(t, client, db, archives[:1])
+
+	cache := NewArchiveCache(t.TempDir())
+	ingest(t, newTestClient(server.URL, cache), db, archives[1:])
+
+	if _, err := RebuildUserData(db, "user", cache); err == nil {
+		t.Errorf("RebuildUserData succeeded without the first archive cached")
+	}
+	if numGames := numRows(t, db, "games"); numGames != 4 {
+		t.Errorf("%d games left after the refused rebuild, expected 4", numGames)
+	}
+}
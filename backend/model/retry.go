@@ -0,0 +1,135 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultMaxAttempts = 5
+	DefaultMinBackoff  = 500 * time.Millisecond
+	DefaultMaxBackoff  = 30 * time.Second
+)
+
+type StatusError struct {
+	Url        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d from %s", e.StatusCode, e.Url)
+}
+
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+// parses both the delay-seconds and http-date forms of the header
+func parseRetryAfter(header string) (delay time.Duration, ok bool) {
+	if header == "" {
+		return
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return
+	}
+
+	delay = time.Until(date)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, true
+}
+
+// exponential backoff with jitter, the delay is picked between half and all of the capped exponential value
+func (c *ChessComClient) backoff(attempt int) time.Duration {
+	delay := c.maxBackoff
+	if shift := attempt - 1; shift < 32 {
+		if exp := c.minBackoff << shift; exp > 0 && exp < c.maxBackoff {
+			delay = exp
+		}
+	}
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// open requests url, retrying network errors, 429s and 5xxs up to the configured number of attempts,
+// waiting for the server's Retry-After up to the maximum backoff. The response is either a 200 or, for
+// conditional requests, a 304. The caller must close its body.
+func (c *ChessComClient) open(ctx context.Context, url string, header http.Header) (resp *http.Response, err error) {
+	for attempt := 1; ; attempt++ {
+		var retryAfter time.Duration
+		var hasRetryAfter bool
+		var retryable bool
+
+		if err = c.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		resp, err = c.get(ctx, url, header)
+		if err != nil {
+			err = fmt.Errorf("error requesting %s: %w", url, err)
+			retryable = ctx.Err() == nil
+		} else if resp.StatusCode == http.StatusOK || (resp.StatusCode == http.StatusNotModified && header != nil) {
+			return
+		} else {
+			err = &StatusError{Url: url, StatusCode: resp.StatusCode}
+			retryable = isRetryableStatus(resp.StatusCode)
+			retryAfter, hasRetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			resp.Body.Close()
+		}
+
+		if !retryable || attempt >= c.maxAttempts {
+			return nil, fmt.Errorf("giving up after %d attempt(s): %w", attempt, err)
+		}
+
+		// capped so a server asking for hours can't stall setup
+		delay := c.backoff(attempt)
+		if hasRetryAfter {
+			delay = retryAfter
+			if delay > c.maxBackoff {
+				delay = c.maxBackoff
+			}
+		}
+		fmt.Printf("Retrying %s in %v (attempt %d / %d): %s\n", url, delay, attempt+1, c.maxAttempts, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *ChessComClient) fetch(ctx context.Context, url string, header http.Header) (body []byte, err error) {
+	resp, err := c.open(ctx, url, header)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = fmt.Errorf("error reading body of %s: %w", url, err)
+	}
+	return
+}
@@ -0,0 +1,184 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newRetryClient returns a client without rate limiting whose backoff is short enough for tests
+func newRetryClient(maxAttempts int, maxBackoff time.Duration) *ChessComClient {
+	config := DefaultChessComConfig()
+	config.MaxAttempts = maxAttempts
+	config.MinBackoff = time.Millisecond
+	config.MaxBackoff = maxBackoff
+	config.RequestsPerSecond = 0
+	return NewChessComClient(config)
+}
+
+// newStatusServer answers each request with the next of statuses, repeating the last one, and counts the
+// requests in numRequests
+func newStatusServer(t *testing.T, retryAfter string, statuses ...int) (server *httptest.Server, numRequests *int32) {
+	t.Helper()
+	numRequests = new(int32)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		i := int(atomic.AddInt32(numRequests, 1)) - 1
+		if i >= len(statuses) {
+			i = len(statuses) - 1
+		}
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(statuses[i])
+	}))
+	t.Cleanup(server.Close)
+	return
+}
+
+func TestOpenRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		statuses    []int
+		maxAttempts int
+		retryAfter  string
+		ok          bool
+		numRequests int32
+		status      int
+	}{
+		{"ok", []int{200}, 3, "", true, 1, 0},
+		{"429 then ok", []int{429, 200}, 3, "", true, 2, 0},
+		{"5xx then ok", []int{500, 502, 200}, 3, "", true, 3, 0},
+		{"5xx until attempts run out", []int{503}, 3, "", false, 3, 503},
+		{"404 is not retried", []int{404, 200}, 3, "", false, 1, 404},
+		{"single attempt", []int{429, 200}, 1, "", false, 1, 429},
+		{"retry after", []int{429, 200}, 3, "0", true, 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, numRequests := newStatusServer(t, tt.retryAfter, tt.statuses...)
+			client := newRetryClient(tt.maxAttempts, 10*time.Millisecond)
+
+			resp, err := client.open(context.Background(), server.URL, nil)
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			if tt.ok != (err == nil) {
+				t.Errorf("open returned %v, expected ok = %v", err, tt.ok)
+			}
+			var statusErr *StatusError
+			if !tt.ok && (!errors.As(err, &statusErr) || statusErr.StatusCode != tt.status) {
+				t.Errorf("open returned %v, expected a %d status error", err, tt.status)
+			}
+			if *numRequests != tt.numRequests {
+				t.Errorf("%d requests made, expected %d", *numRequests, tt.numRequests)
+			}
+		})
+	}
+}
+
+func TestOpenCapsRetryAfter(t *testing.T) {
+	server, numRequests := newStatusServer(t, "86400", 429, 200)
+	client := newRetryClient(2, 20*time.Millisecond)
+
+	start := time.Now()
+	resp, err := client.open(context.Background(), server.URL, nil)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	resp.Body.Close()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("open waited %v for a Retry-After of a day, expected it to be capped at 20ms", elapsed)
+	}
+	if *numRequests != 2 {
+		t.Errorf("%d requests made, expected 2", *numRequests)
+	}
+}
+
+func TestOpenCancelled(t *testing.T) {
+	server, numRequests := newStatusServer(t, "60", 503)
+	client := newRetryClient(5, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.open(ctx, server.URL, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("open returned %v, expected the context error", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("open took %v to notice the cancelled context", elapsed)
+	}
+	if *numRequests != 1 {
+		t.Errorf("%d requests made, expected the cancelled context to stop the retry", *numRequests)
+	}
+}
+
+func TestOpenNotModified(t *testing.T) {
+	server, _ := newStatusServer(t, "", 304)
+	client := newRetryClient(1, time.Millisecond)
+
+	// 304 only answers conditional requests
+	resp, err := client.open(context.Background(), server.URL, http.Header{"If-None-Match": {`"etag"`}})
+	if err != nil || resp.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional request returned %v, %v, expected a 304", resp, err)
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	if _, err := client.open(context.Background(), server.URL, nil); err == nil {
+		t.Errorf("unconditional request accepted a 304")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		delay  time.Duration
+		ok     bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0, true},
+	}
+
+	for _, tt := range tests {
+		delay, ok := parseRetryAfter(tt.header)
+		if delay != tt.delay || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, expected %v, %v", tt.header, delay, ok, tt.delay, tt.ok)
+		}
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if delay, ok := parseRetryAfter(future); !ok || delay < 59*time.Minute || delay > time.Hour {
+		t.Errorf("parseRetryAfter(%q) = %v, %v, expected about an hour", future, delay, ok)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	client := newRetryClient(10, time.Second)
+	client.minBackoff = 100 * time.Millisecond
+
+	for attempt, cap := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		for i := 0; i < 20; i++ {
+			if delay := client.backoff(attempt + 1); delay < cap/2 || delay > cap {
+				t.Errorf("backoff(%d) = %v, expected between %v and %v", attempt+1, delay, cap/2, cap)
+			}
+		}
+	}
+
+	// large attempt numbers must not overflow the shift
+	if delay := client.backoff(100); delay < 500*time.Millisecond || delay > time.Second {
+		t.Errorf("backoff(100) = %v, expected between 500ms and 1s", delay)
+	}
+}
@@ -0,0 +1,95 @@
+package model
+
+// Zobrist keys are stored in the dbs as fen ids, so the seed and the order they are generated in must
+// never change
+const zobristSeed = 0x5eed_c0de_cafe_f00d
+
+type zobristKeys struct {
+	pieces [12][64]uint64
+	// castling rights are keyed by the rook square so chess960 rights hash correctly
+	castling [2][64]uint64
+	epFile   [8]uint64
+	black    uint64
+}
+
+// generated in a var initialiser rather than init so other package level vars can hash positions
+var zobrist = newZobristKeys()
+
+const zobristPieceTypes = "PNBRQK"
+
+// splitmix64 is used instead of math/rand so the keys don't depend on the standard library's generator
+func splitmix64(state *uint64) uint64 {
+	*state += 0x9e3779b97f4a7c15
+	z := *state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func newZobristKeys() (keys zobristKeys) {
+	state := uint64(zobristSeed)
+	for piece := range keys.pieces {
+		for sq := range keys.pieces[piece] {
+			keys.pieces[piece][sq] = splitmix64(&state)
+		}
+	}
+	for color := range keys.castling {
+		for sq := range keys.castling[color] {
+			keys.castling[color][sq] = splitmix64(&state)
+		}
+	}
+	for file := range keys.epFile {
+		keys.epFile[file] = splitmix64(&state)
+	}
+	keys.black = splitmix64(&state)
+	return
+}
+
+func zobristPieceIndex(piece byte) int {
+	for i := 0; i < len(zobristPieceTypes); i++ {
+		if zobristPieceTypes[i] == pieceType(piece) {
+			return i*2 + int(pieceColor(piece))
+		}
+	}
+	return -1
+}
+
+// Hash is the Zobrist hash of the position, covering the same fields as PositionFen, so boards with
+// equal position FENs hash equally. It is signed so it fits an sqlite INTEGER.
+func (b *Board) Hash() int64 {
+	var hash uint64
+	for sq, piece := range b.squares {
+		if piece == 0 {
+			continue
+		}
+		if i := zobristPieceIndex(piece); i >= 0 {
+			hash ^= zobrist.pieces[i][sq]
+		}
+	}
+
+	for color := White; color <= Black; color++ {
+		for side := kingside; side <= queenside; side++ {
+			if rook := b.castleRooks[color][side]; rook != noSquare {
+				hash ^= zobrist.castling[color][rook]
+			}
+		}
+	}
+
+	if b.epCapturable() {
+		hash ^= zobrist.epFile[fileOf(b.epSquare)]
+	}
+	if b.turn == Black {
+		hash ^= zobrist.black
+	}
+
+	return int64(hash)
+}
+
+// FenHash is the Hash of a FEN's position, ignoring its move counters
+func FenHash(fen string) (hash int64, err error) {
+	b, err := ParseFen(fen)
+	if err != nil {
+		return
+	}
+	return b.Hash(), nil
+}
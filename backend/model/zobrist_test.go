@@ -0,0 +1,59 @@
+package model
+
+import "testing"
+
+// the hashes are the ids of the fens table, so changing the keys or what they cover invalidates every stored db
+func TestHashPinned(t *testing.T) {
+	tests := []struct {
+		name string
+		fen  string
+		hash int64
+	}{
+		{"start", StartingFen, -8802589291994135172},
+		{"uncapturable ep", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1", -4598631066194158818},
+		{"capturable ep", "rnbqkb1r/ppp1pppp/5n2/3pP3/8/8/PPPP1PPP/RNBQKBNR w KQkq d6 0 3", 4928343355765214743},
+		{"kiwipete", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", -795970733508316692},
+		{"kiwipete black to move", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R b KQkq - 0 1", 8869094959027834688},
+		{"chess960", "bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w HFhf - 2 9", 2923414935299608027},
+		{"chess960 without castling", "bqnb1rkr/pp3ppp/3ppn2/2p5/5P2/P2P4/NPP1P1PP/BQ1BNRKR w - - 2 9", 7803528894423974175},
+	}
+
+	for _, tt := range tests {
+		hash, err := FenHash(tt.fen)
+		if err != nil {
+			t.Fatalf("FenHash(%q): %s", tt.fen, err)
+		}
+		if hash != tt.hash {
+			t.Errorf("%s: hash %d, expected %d", tt.name, hash, tt.hash)
+		}
+	}
+
+	if hash := NewBoard().Hash(); hash != tests[0].hash {
+		t.Errorf("NewBoard().Hash() = %d, expected %d", hash, tests[0].hash)
+	}
+}
+
+func TestHashMatchesPositionFen(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		moves string
+		other string
+	}{
+		{"move counters", StartingFen, "", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 12 40"},
+		{"uncapturable ep", StartingFen, "e4", "rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq - 0 1"},
+		{"transposition", StartingFen, "Nf3 Nf6 e4", "rnbqkb1r/pppppppp/5n2/8/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 1 2"},
+		{"shredder and x-fen", "r3k2r/8/8/8/8/8/8/R3K2R w HAha - 0 1", "", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1"},
+	}
+
+	for _, tt := range tests {
+		b := playSan(t, tt.fen, tt.moves)
+		other, err := ParseFen(tt.other)
+		if err != nil {
+			t.Fatalf("ParseFen(%q): %s", tt.other, err)
+		}
+		if b.Hash() != other.Hash() || b.PositionFen() != other.PositionFen() {
+			t.Errorf("%s: %s hashes to %d and %s to %d, expected them to be equal", tt.name, b.PositionFen(), b.Hash(), other.PositionFen(), other.Hash())
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package types
 
 import (
+	"backend/model"
 	"database/sql"
+	"sort"
 	"sync"
+	"time"
 )
 
 type LockedResource[T any] struct {
@@ -26,26 +29,86 @@ const (
 	SetupStatusUpdating SetupStatus = "Updating"
 	SetupStatusStarted  SetupStatus = "Started"
 	SetupStatusComplete SetupStatus = "Complete"
+	SetupStatusPartial  SetupStatus = "Partial"
 	SetupStatusFailed   SetupStatus = "Failed"
 )
 
-type DBMap map[string]*LockedDB
+type RebuildStatus string
+
+const (
+	RebuildStatusRunning  RebuildStatus = "Running"
+	RebuildStatusComplete RebuildStatus = "Complete"
+)
+
+type RebuildResult struct {
+	Stats *model.InsertStatistics `json:"stats,omitempty"`
+	Error string                  `json:"error,omitempty"`
+}
+
+// RebuildJob is the progress of a rebuild started through the admin endpoint
+type RebuildJob struct {
+	Status     RebuildStatus `json:"status"`
+	StartedAt  time.Time     `json:"startedAt"`
+	FinishedAt *time.Time    `json:"finishedAt,omitempty"`
+	NumUsers   int           `json:"users"`
+
+	// keyed by user id, users that haven't been rebuilt yet are left out
+	Results map[string]RebuildResult `json:"results"`
+}
+
+// DBMap holds the db of each user by user id. Setups add to it while requests read it, so it is only used
+// through its methods, which lock it.
+type DBMap LockedResource[map[string]*LockedDB]
+
+// Get returns the db of userId, nil if the user has none
+func (m *DBMap) Get(userId string) *LockedDB {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+	return (*m.Resource)[userId]
+}
+
+func (m *DBMap) Set(userId string, db *LockedDB) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+	(*m.Resource)[userId] = db
+}
+
+// UserIds lists the users with a db, sorted
+func (m *DBMap) UserIds() (userIds []string) {
+	m.Mu.Lock()
+	defer m.Mu.Unlock()
+	for userId := range *m.Resource {
+		userIds = append(userIds, userId)
+	}
+	sort.Strings(userIds)
+	return
+}
+
 type SetupStatuses LockedResource[map[string]SetupStatus]
 
+// LatestRebuild is nil until the first rebuild is started
+type LatestRebuild LockedResource[RebuildJob]
+
 type ServerState struct {
 	DBMap         DBMap
 	SetupStatuses SetupStatuses
+	LatestRebuild LatestRebuild
+	ChessCom      *model.ChessComClient
 }
 
-func NewServerState() *ServerState {
+func NewServerState(chessCom *model.ChessComClient) *ServerState {
 	dbMap := make(map[string]*LockedDB)
 
 	setupStatuses := make(map[string]SetupStatus)
 	return &ServerState{
-		DBMap: dbMap,
+		DBMap: DBMap{
+			Mu:       sync.Mutex{},
+			Resource: &dbMap,
+		},
 		SetupStatuses: SetupStatuses{
 			Mu:       sync.Mutex{},
 			Resource: &setupStatuses,
 		},
+		ChessCom: chessCom,
 	}
 }
@@ -19,7 +19,7 @@ export async function fetchStats(search) {
       return reject(new Error("Error polling setup: no id in initial response"))
     }
 
-    if(result.data.status === "Complete") {
+    if(result.data.status === "Complete" || result.data.status === "Partial") {
       return resolve()
     }
 
@@ -37,7 +37,7 @@ export async function fetchStats(search) {
           return
         }
 
-        if(pollRes.data.status === "Complete") {
+        if(pollRes.data.status === "Complete" || pollRes.data.status === "Partial") {
           resolve()
           clearTimeout(timeout)
         } else {